@@ -0,0 +1,180 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/philippfranke/multipart-related/related"
+)
+
+// extract writes the parts of a message to a directory.
+func extract(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("extract", flag.ContinueOnError)
+	contentType := flags.String("content-type", "", "Content-Type of the message")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return errUsage
+	}
+	object, err := readObjectFile(flags.Arg(0), *contentType)
+	if err != nil {
+		return err
+	}
+	manifest, err := object.Extract(flags.Arg(1))
+	if err != nil {
+		return err
+	}
+	for _, e := range manifest {
+		fmt.Fprintln(stdout, e.Path)
+	}
+	return nil
+}
+
+// convert translates between MHTML files, directories and zip archives.
+// The format is taken from the path: an existing directory or a path
+// ending in a separator is a directory, a ".zip" suffix an archive and
+// anything else an MHTML file.
+func convert(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("convert", flag.ContinueOnError)
+	root := flags.String("root", "index.html", "root file when reading a directory or archive")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return errUsage
+	}
+	in, out := flags.Arg(0), flags.Arg(1)
+
+	object, err := loadObject(in, *root)
+	if err != nil {
+		return err
+	}
+	switch format(out) {
+	case "dir":
+		_, err = object.Extract(out)
+		return err
+	case "zip":
+		return writeZip(object, out)
+	}
+	return writeObject(out, stdout, object)
+}
+
+func format(name string) string {
+	if fi, err := os.Stat(name); err == nil && fi.IsDir() {
+		return "dir"
+	}
+	switch {
+	case strings.HasSuffix(name, "/"), strings.HasSuffix(name, string(filepath.Separator)):
+		return "dir"
+	case strings.EqualFold(filepath.Ext(name), ".zip"):
+		return "zip"
+	}
+	return "mhtml"
+}
+
+// loadObject reads a compound object from an MHTML file, or packs a
+// directory or archive with root as root, leaving out the manifest.
+func loadObject(name, root string) (*related.Object, error) {
+	var fsys fs.FS
+	switch format(name) {
+	case "mhtml":
+		return readObjectFile(name, "")
+	case "dir":
+		fsys = os.DirFS(name)
+	case "zip":
+		zr, err := zip.OpenReader(name)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		fsys = zr
+	}
+
+	var b bytes.Buffer
+	w := related.NewWriter(&b)
+	if err := w.WriteFS(manifestFS{fsys}, root); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return readObject(&b, w.FormDataContentType())
+}
+
+// manifestFS hides the manifest written by Object.Extract from WriteFS.
+type manifestFS struct {
+	fs.FS
+}
+
+func (f manifestFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	entries, err := fs.ReadDir(f.FS, name)
+	if name != "." {
+		return entries, err
+	}
+	filtered := entries[:0]
+	for _, e := range entries {
+		if e.Name() != related.ManifestName {
+			filtered = append(filtered, e)
+		}
+	}
+	return filtered, err
+}
+
+// writeZip extracts object to a temporary directory and archives it,
+// leaving out the manifest.
+func writeZip(object *related.Object, name string) error {
+	dir, err := ioutil.TempDir("", "related")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	if _, err := object.Extract(dir); err != nil {
+		return err
+	}
+
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(f)
+	err = filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.Mode().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil || rel == related.ManifestName {
+			return err
+		}
+		w, err := zw.Create(filepath.ToSlash(rel))
+		if err != nil {
+			return err
+		}
+		content, err := ioutil.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		_, err = w.Write(content)
+		return err
+	})
+	if err == nil {
+		err = zw.Close()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,61 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// inspect prints the parts of a message with their headers and sizes,
+// marking the root with an asterisk.
+func inspect(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	contentType := flags.String("content-type", "", "Content-Type of the message")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	object, err := readObjectFile(flags.Arg(0), *contentType)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(stdout, "%s\n", object.ContentType())
+	for i, oh := range object.Values {
+		root := " "
+		if oh.Root {
+			root = "*"
+		}
+		fmt.Fprintf(stdout, "%s %d. %s (%d bytes)\n", root, i, describe(oh.ContentId(), oh.Header.Get("Content-Location")), len(oh.Bytes()))
+		keys := make([]string, 0, len(oh.Header))
+		for k := range oh.Header {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Fprintf(stdout, "     %s: %s\n", k, strings.Join(oh.Header[k], ", "))
+		}
+	}
+	return nil
+}
+
+// describe names a part by its Content-ID and Content-Location.
+func describe(contentId, location string) string {
+	var names []string
+	if contentId != "" {
+		names = append(names, "<"+contentId+">")
+	}
+	if location != "" {
+		names = append(names, location)
+	}
+	if len(names) == 0 {
+		return "-"
+	}
+	return strings.Join(names, " ")
+}
@@ -0,0 +1,93 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+// Command related inspects, extracts, packs, validates and converts
+// MIME multipart/related messages.
+//
+// Usage:
+//
+//	related inspect  [-content-type ct] [file]
+//	related extract  [-content-type ct] file dir
+//	related pack     [-start id] [-type t] [-start-info s] [-o file] root [file ...]
+//	related validate [-content-type ct] [file]
+//	related convert  [-root name] input output
+//
+// Messages are read from file, or standard input if file is "-" or
+// missing. Without -content-type, the Content-Type is taken from a
+// leading header block, as MHTML files have, and the boundary is detected
+// from the body.
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/philippfranke/multipart-related/related"
+)
+
+var commands = map[string]func(args []string, stdout io.Writer) error{
+	"inspect":  inspect,
+	"extract":  extract,
+	"pack":     pack,
+	"validate": validate,
+	"convert":  convert,
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "related: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+var errUsage = errors.New("usage: related inspect|extract|pack|validate|convert [flags] [args]")
+
+func run(args []string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	cmd, ok := commands[args[0]]
+	if !ok {
+		return errUsage
+	}
+	return cmd(args[1:], stdout)
+}
+
+// openInput opens name, or standard input for "-" or "".
+func openInput(name string) (io.ReadCloser, error) {
+	if name == "" || name == "-" {
+		return os.Stdin, nil
+	}
+	return os.Open(name)
+}
+
+// readObject reads a compound object from r. Without contentType, the
+// boundary is sniffed, see related.SniffReader.
+func readObject(r io.Reader, contentType string) (*related.Object, error) {
+	if contentType == "" {
+		reader, err := related.SniffReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return reader.ReadObject()
+	}
+	reader, err := related.NewReaderFromContentType(r, contentType)
+	if err != nil {
+		return nil, err
+	}
+	return reader.ReadObject()
+}
+
+// readObjectFile reads a compound object from the file name.
+func readObjectFile(name, contentType string) (*related.Object, error) {
+	f, err := openInput(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readObject(f, contentType)
+}
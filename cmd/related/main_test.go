@@ -0,0 +1,199 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestFiles(t *testing.T, dir string) {
+	files := map[string]string{
+		"index.html": `<img src="logo.png">`,
+		"logo.png":   "\x89PNG\r\n\x1a\n",
+		"notes.txt":  "Life?",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCommands(t *testing.T) {
+	dir, err := ioutil.TempDir("", "related")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTestFiles(t, dir)
+
+	mhtml := filepath.Join(dir, "page.mhtml")
+	var out bytes.Buffer
+	err = run([]string{"pack", "-start", "page@x", "-start-info", "-o p", "-o", mhtml,
+		filepath.Join(dir, "index.html"), filepath.Join(dir, "logo.png")}, &out)
+	if err != nil {
+		t.Fatalf("pack: %v", err)
+	}
+
+	out.Reset()
+	if err := run([]string{"inspect", mhtml}, &out); err != nil {
+		t.Fatalf("inspect: %v", err)
+	}
+	for _, want := range []string{
+		`start="<page@x>"`,
+		"* 0. <page@x> index.html (20 bytes)",
+		"  1. logo.png (8 bytes)",
+		"     Content-Type: image/png",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("inspect = %s, want %s", out.String(), want)
+		}
+	}
+
+	out.Reset()
+	if err := run([]string{"validate", mhtml}, &out); err != nil {
+		t.Errorf("validate: %v\n%s", err, out.String())
+	}
+
+	out.Reset()
+	extracted := filepath.Join(dir, "extracted")
+	if err := run([]string{"extract", mhtml, extracted}, &out); err != nil {
+		t.Fatalf("extract: %v", err)
+	}
+	if g, w := out.String(), "index.html\nlogo.png\n"; g != w {
+		t.Errorf("extract = %q, want %q", g, w)
+	}
+}
+
+func TestConvert(t *testing.T) {
+	dir, err := ioutil.TempDir("", "related")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "src")
+	if err := os.Mkdir(src, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFiles(t, src)
+
+	var out bytes.Buffer
+	archive := filepath.Join(dir, "page.zip")
+	mhtml := filepath.Join(dir, "page.mht")
+	dst := filepath.Join(dir, "dst") + string(filepath.Separator)
+	for _, args := range [][]string{
+		{"convert", src, archive},
+		{"convert", archive, mhtml},
+		{"convert", mhtml, dst},
+	} {
+		if err := run(args, &out); err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+	}
+
+	for _, name := range []string{"index.html", "logo.png", "notes.txt"} {
+		want, _ := ioutil.ReadFile(filepath.Join(src, name))
+		got, err := ioutil.ReadFile(filepath.Join(dst, name))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+
+	repacked := filepath.Join(dir, "repacked.mht")
+	if err := run([]string{"convert", dst, repacked}, &out); err != nil {
+		t.Fatalf("convert %s: %v", dst, err)
+	}
+	object, err := readObjectFile(repacked, "")
+	if err != nil {
+		t.Fatalf("reading %s: %v", repacked, err)
+	}
+	if len(object.Values) != 3 {
+		t.Errorf("repacked %d parts, want 3", len(object.Values))
+	}
+
+	out.Reset()
+	if err := run([]string{"validate", mhtml}, &out); err != nil {
+		t.Errorf("validate: %v", err)
+	}
+	if !strings.Contains(out.String(), "info [part-unreferenced] part 2") ||
+		strings.Count(out.String(), "\n") != 2 {
+		t.Errorf("validate = %s, want orphan warning", out.String())
+	}
+}
+
+func TestValidateInvalid(t *testing.T) {
+	f, err := ioutil.TempFile("", "related")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("Content-Type: multipart/related; boundary=b; type=\"text/html\"\r\n\r\n" +
+		"--b\r\nContent-Type: text/plain\r\n\r\nLife?\r\n")
+	f.Close()
+
+	var out bytes.Buffer
+	if err := run([]string{"validate", f.Name()}, &out); err != errInvalid {
+		t.Errorf("validate = %v, want %v", err, errInvalid)
+	}
+	for _, rule := range []string{"close-delimiter-missing", "root-type-mismatch"} {
+		if !strings.Contains(out.String(), rule) {
+			t.Errorf("validate = %s, want %s", out.String(), rule)
+		}
+	}
+}
+
+func TestUsage(t *testing.T) {
+	for _, args := range [][]string{nil, {"bogus"}, {"pack"}, {"extract", "a"}} {
+		if err := run(args, &bytes.Buffer{}); err != errUsage {
+			t.Errorf("%v = %v, want %v", args, err, errUsage)
+		}
+	}
+}
+
+func TestInspectHeaderless(t *testing.T) {
+	f, err := ioutil.TempFile("", "related")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("--b\r\nContent-Type: text/plain\r\n\r\nLife?\r\n--b--\r\n")
+	f.Close()
+
+	var out bytes.Buffer
+	if err := run([]string{"inspect", f.Name()}, &out); err != nil {
+		t.Fatalf("inspect: %v", err)
+	}
+	if want := "* 0. - (5 bytes)"; !strings.Contains(out.String(), want) {
+		t.Errorf("inspect = %s, want %s", out.String(), want)
+	}
+}
+
+func TestValidateHeaderless(t *testing.T) {
+	f, err := ioutil.TempFile("", "related")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("--b\r\nContent-Type: text/plain\r\n\r\nLife?\r\n--b--\r\n")
+	f.Close()
+
+	var out bytes.Buffer
+	if err := run([]string{"validate", f.Name()}, &out); err != nil {
+		t.Fatalf("validate: %v\n%s", err, out.String())
+	}
+	if g, w := out.String(), "ok\n"; g != w {
+		t.Errorf("validate = %q, want %q", g, w)
+	}
+}
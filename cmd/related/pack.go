@@ -0,0 +1,107 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/textproto"
+	"path/filepath"
+
+	"github.com/philippfranke/multipart-related/related"
+)
+
+// pack builds a message from files, the first one being the root.
+func pack(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("pack", flag.ContinueOnError)
+	start := flags.String("start", "", "Content-ID of the root")
+	mediaType := flags.String("type", "", "media type of the root, detected if empty")
+	startInfo := flags.String("start-info", "", "start-info parameter")
+	out := flags.String("o", "-", "output file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return errUsage
+	}
+
+	var b bytes.Buffer
+	w := related.NewWriter(&b)
+	w.SetStartInfo(*startInfo)
+	if err := w.SetMessageHeader(nil); err != nil {
+		return err
+	}
+	for i, name := range flags.Args() {
+		content, err := ioutil.ReadFile(name)
+		if err != nil {
+			return err
+		}
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Location", filepath.ToSlash(filepath.Base(name)))
+
+		var pw io.Writer
+		if i == 0 {
+			t := *mediaType
+			if t == "" {
+				t = related.DetectMediaType(name, content)
+			}
+			pw, err = w.CreateRoot(*start, t, header)
+		} else {
+			header.Set("Content-Type", related.DetectMediaType(name, content))
+			pw, err = w.CreatePart("", header)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		if _, err := pw.Write(content); err != nil {
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return writeOutput(*out, stdout, b.Bytes())
+}
+
+// writeObject writes object as a message with header block, e.g. an
+// MHTML file, to the file name, or stdout for "-".
+func writeObject(name string, stdout io.Writer, object *related.Object) error {
+	if err := object.Validate(); err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	w := related.NewWriter(&b)
+	if err := w.SetMessageHeader(nil); err != nil {
+		return err
+	}
+	if boundary := object.Params["boundary"]; boundary != "" {
+		if err := w.SetBoundary(boundary); err != nil {
+			return err
+		}
+	}
+	w.SetStartInfo(object.Params["start-info"])
+	for _, oh := range object.Values {
+		if err := w.CopyPart(oh.Part()); err != nil {
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return writeOutput(name, stdout, b.Bytes())
+}
+
+// writeOutput writes a message to the file name, or stdout for "-".
+func writeOutput(name string, stdout io.Writer, message []byte) error {
+	if name == "-" {
+		_, err := stdout.Write(message)
+		return err
+	}
+	return ioutil.WriteFile(name, message, 0644)
+}
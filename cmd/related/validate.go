@@ -0,0 +1,108 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/mail"
+
+	"github.com/philippfranke/multipart-related/related"
+)
+
+var errInvalid = errors.New("message is invalid")
+
+// validate lints a message and prints the diagnostics. It fails if any of
+// them is an error.
+func validate(args []string, stdout io.Writer) error {
+	flags := flag.NewFlagSet("validate", flag.ContinueOnError)
+	contentType := flags.String("content-type", "", "Content-Type of the message")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	f, err := openInput(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	body, err := ioutil.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	ct := *contentType
+	if ct == "" {
+		if body, ct, err = sniffBody(body, stdout); err != nil {
+			return err
+		}
+	}
+
+	failed := false
+	for _, d := range related.Lint(body, ct) {
+		if d.Severity == related.SeverityError {
+			failed = true
+		}
+		fmt.Fprintln(stdout, d)
+	}
+	if failed {
+		return errInvalid
+	}
+	fmt.Fprintln(stdout, "ok")
+	return nil
+}
+
+// sniffBody detects the Content-Type of a message, see related.SniffReader,
+// and returns it with the body following the header block, if any.
+// Anomalies found sniffing are printed as warnings. Without header block,
+// the type parameter is taken from the root part.
+func sniffBody(message []byte, stdout io.Writer) ([]byte, string, error) {
+	reader, err := related.SniffReader(bytes.NewReader(message))
+	if err != nil {
+		return nil, "", err
+	}
+	for _, w := range reader.Warnings() {
+		fmt.Fprintf(stdout, "%s [content-type-sniffed]: %s\n", related.SeverityWarning, w)
+	}
+	ct := reader.ContentType()
+	if reader.Header() == nil {
+		if object, err := reader.ReadObject(); err == nil {
+			ct = withRootType(ct, object)
+		}
+		return message, ct, nil
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(message))
+	if err != nil {
+		return nil, "", fmt.Errorf("reading header: %v", err)
+	}
+	body, err := ioutil.ReadAll(msg.Body)
+	return body, ct, err
+}
+
+// withRootType adds the media type of the root part as type parameter to
+// contentType.
+func withRootType(contentType string, object *related.Object) string {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil || params["type"] != "" {
+		return contentType
+	}
+	for _, oh := range object.Values {
+		if !oh.Root {
+			continue
+		}
+		t, _, err := mime.ParseMediaType(oh.Header.Get("Content-Type"))
+		if err != nil {
+			return contentType
+		}
+		params["type"] = t
+		return mime.FormatMediaType(mediaType, params)
+	}
+	return contentType
+}
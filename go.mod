@@ -0,0 +1,3 @@
+module github.com/philippfranke/multipart-related
+
+go 1.16
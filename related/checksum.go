@@ -0,0 +1,273 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package related
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"mime/quotedprintable"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// ErrChecksum is returned, wrapped in a PartError, when reading a part
+// whose content doesn't match its Content-Length, Content-MD5 or
+// Content-Digest.
+var ErrChecksum = errors.New("content doesn't match checksum")
+
+// A Checksum selects the integrity headers a Writer adds to each part.
+// Content-Length counts the body octets as written, like in HTTP. The
+// other headers cover the part's content, i.e. after decoding a base64 or
+// quoted-printable Content-Transfer-Encoding.
+type Checksum uint
+
+const (
+	// ContentLength adds Content-Length.
+	ContentLength Checksum = 1 << iota
+
+	// ContentMD5 adds Content-MD5, see RFC 1864.
+	ContentMD5
+
+	// DigestSHA256 and DigestSHA512 add Content-Digest, see RFC 9530.
+	DigestSHA256
+	DigestSHA512
+)
+
+// digestAlgorithms are the Content-Digest algorithms known to the Reader.
+var digestAlgorithms = map[string]func() hash.Hash{
+	"sha-256": sha256.New,
+	"sha-512": sha512.New,
+}
+
+// SetChecksums makes the Writer add the selected integrity headers to
+// each part. As headers precede the content, parts are buffered in memory
+// until the next part is created or the Writer is closed. SetChecksums
+// must be called before any parts are created.
+func (w *Writer) SetChecksums(c Checksum) error {
+	if w.n > 0 {
+		return errors.New("SetChecksums called after parts were created")
+	}
+	w.checksums = c
+	return nil
+}
+
+// pendingPart is a part buffered for computing its checksums.
+type pendingPart struct {
+	header textproto.MIMEHeader
+	body   bytes.Buffer
+	index  int
+}
+
+// flush writes the buffered part, if any, adding its checksum headers.
+func (w *Writer) flush() error {
+	p := w.pending
+	if p == nil {
+		return nil
+	}
+	w.pending = nil
+
+	var err error
+	if w.checksums != 0 {
+		err = addChecksums(p.header, p.body.Bytes(), w.checksums)
+	}
+	var pw io.Writer
+	if err == nil {
+		pw, err = w.startPart(p.index, p.header)
+	}
+	if err == nil {
+		_, err = pw.Write(p.body.Bytes())
+	}
+	if _, ok := err.(*PartError); ok {
+		return err
+	}
+	if err != nil {
+		return &PartError{
+			Part:      p.index,
+			ContentId: parseContentId(p.header.Get("Content-Id")),
+			Offset:    w.cw.n,
+			Err:       err,
+		}
+	}
+	return nil
+}
+
+// addChecksums sets the selected integrity headers of a part with the
+// given body.
+func addChecksums(header textproto.MIMEHeader, body []byte, c Checksum) error {
+	content := body
+	if c&^ContentLength != 0 {
+		var err error
+		if content, err = decodeBody(header.Get("Content-Transfer-Encoding"), body); err != nil {
+			return err
+		}
+	}
+
+	if c&ContentLength != 0 {
+		header.Set("Content-Length", strconv.Itoa(len(body)))
+	}
+	if c&ContentMD5 != 0 {
+		sum := md5.Sum(content)
+		header.Set("Content-MD5", base64.StdEncoding.EncodeToString(sum[:]))
+	}
+	var digests []string
+	for _, d := range []struct {
+		c   Checksum
+		alg string
+	}{{DigestSHA256, "sha-256"}, {DigestSHA512, "sha-512"}} {
+		if c&d.c != 0 {
+			h := digestAlgorithms[d.alg]()
+			h.Write(content)
+			digests = append(digests, d.alg+"=:"+base64.StdEncoding.EncodeToString(h.Sum(nil))+":")
+		}
+	}
+	if len(digests) > 0 {
+		header.Set("Content-Digest", strings.Join(digests, ", "))
+	}
+	return nil
+}
+
+// decodeBody returns the content of a body in the given
+// Content-Transfer-Encoding, as the Reader decodes it.
+func decodeBody(cte string, body []byte) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(cte)) {
+	case "", "7bit", "8bit", "binary":
+		return body, nil
+	case "base64":
+		decoded := make([]byte, base64.StdEncoding.DecodedLen(len(body)))
+		n, err := base64.StdEncoding.Decode(decoded, body)
+		return decoded[:n], err
+	case "quoted-printable":
+		return ioutil.ReadAll(quotedprintable.NewReader(bytes.NewReader(body)))
+	}
+	return nil, fmt.Errorf("can't compute checksums of Content-Transfer-Encoding %q", cte)
+}
+
+// verifier checks the content read through it against the part's
+// Content-MD5 and Content-Digest, and the encoded octets counted by count
+// against its Content-Length, at EOF.
+type verifier struct {
+	r      io.Reader
+	n      int64
+	length int64 // -1 if unknown
+	hashes []verifierHash
+
+	// err is an invalid integrity header, reported at EOF
+	err error
+}
+
+type verifierHash struct {
+	name string
+	h    hash.Hash
+	want []byte
+}
+
+// newVerifier returns a verifier for the integrity headers of header, or
+// nil if it has none. The content to verify is read from its r.
+func newVerifier(header textproto.MIMEHeader) *verifier {
+	v := &verifier{length: -1}
+	if s := header.Get("Content-Length"); s != "" {
+		n, err := strconv.ParseInt(strings.TrimSpace(s), 10, 64)
+		if err != nil || n < 0 {
+			v.err = fmt.Errorf("%w: invalid Content-Length %q", ErrChecksum, s)
+		}
+		v.length = n
+	}
+	if s := header.Get("Content-Md5"); s != "" {
+		want, err := base64.StdEncoding.DecodeString(strings.TrimSpace(s))
+		if err != nil {
+			v.err = fmt.Errorf("%w: invalid Content-MD5 %q", ErrChecksum, s)
+		}
+		v.hashes = append(v.hashes, verifierHash{"Content-MD5", md5.New(), want})
+	}
+	for _, member := range strings.Split(header.Get("Content-Digest"), ",") {
+		member = strings.TrimSpace(member)
+		if i := strings.IndexByte(member, ';'); i >= 0 {
+			member = member[:i]
+		}
+		i := strings.IndexByte(member, '=')
+		if i < 0 {
+			continue
+		}
+		alg := strings.ToLower(strings.TrimSpace(member[:i]))
+		newHash, ok := digestAlgorithms[alg]
+		if !ok {
+			continue
+		}
+		value := strings.TrimSpace(member[i+1:])
+		want, err := base64.StdEncoding.DecodeString(strings.Trim(value, ":"))
+		if err != nil || len(value) < 2 || value[0] != ':' || value[len(value)-1] != ':' {
+			v.err = fmt.Errorf("%w: invalid Content-Digest %s", ErrChecksum, alg)
+		}
+		v.hashes = append(v.hashes, verifierHash{"Content-Digest " + alg, newHash(), want})
+	}
+	if v.length < 0 && len(v.hashes) == 0 && v.err == nil {
+		return nil
+	}
+	return v
+}
+
+// count returns a reader counting the encoded octets read from r for
+// checking Content-Length.
+func (v *verifier) count(r io.Reader) io.Reader {
+	return &octetCounter{r: r, n: &v.n}
+}
+
+// octetCounter counts the bytes read from r.
+type octetCounter struct {
+	r io.Reader
+	n *int64
+}
+
+func (c *octetCounter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	*c.n += int64(n)
+	return n, err
+}
+
+func (v *verifier) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	for _, h := range v.hashes {
+		h.h.Write(p[:n])
+	}
+	if err == io.EOF {
+		if verr := v.verify(); verr != nil {
+			return n, verr
+		}
+	}
+	return n, err
+}
+
+func (v *verifier) verify() error {
+	if v.err != nil {
+		return v.err
+	}
+	if v.length >= 0 && v.n != v.length {
+		return fmt.Errorf("%w: Content-Length is %d, body has %d bytes", ErrChecksum, v.length, v.n)
+	}
+	for _, h := range v.hashes {
+		if !bytes.Equal(h.h.Sum(nil), h.want) {
+			return fmt.Errorf("%w: %s mismatch", ErrChecksum, h.name)
+		}
+	}
+	return nil
+}
+
+// dropChecksums removes the integrity headers of a part whose content
+// changed.
+func dropChecksums(header textproto.MIMEHeader) {
+	for _, k := range []string{"Content-Length", "Content-Md5", "Content-Digest"} {
+		header.Del(k)
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package related
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+func TestChecksums(t *testing.T) {
+	var b bytes.Buffer
+	w := NewWriter(&b)
+	if err := w.SetChecksums(ContentLength | ContentMD5 | DigestSHA256 | DigestSHA512); err != nil {
+		t.Fatalf("SetChecksums: %v", err)
+	}
+	root, err := w.CreateRoot("a@b.c", "text/plain", nil)
+	if err != nil {
+		t.Fatalf("CreateRoot: %v", err)
+	}
+	io.WriteString(root, "Life?")
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Type", "text/plain")
+	h.Set("Content-Transfer-Encoding", "base64")
+	part, err := w.CreatePart("b@c.d", h)
+	if err != nil {
+		t.Fatalf("CreatePart: %v", err)
+	}
+	io.WriteString(part, "RG9uJ3QgdGFsayB0byBtZSBhYm91dCBsaWZlIQ==")
+	if err := w.SetChecksums(0); err == nil {
+		t.Errorf("SetChecksums accepted after parts were created")
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	for _, want := range []string{
+		"Content-Length: 5\r\n",
+		"Content-Md5: aamXw9Tusa4UPXHukcB2Ng==\r\n",
+		"Content-Digest: sha-256=:",
+		",\r\n sha-512=:",
+		"Content-Length: 40\r\n",
+	} {
+		if !strings.Contains(b.String(), want) {
+			t.Errorf("message = %q, want %q", b.String(), want)
+		}
+	}
+
+	params := map[string]string{"boundary": w.Boundary()}
+	object, err := NewReader(bytes.NewReader(b.Bytes()), params).ReadObject()
+	if err != nil {
+		t.Fatalf("ReadObject: %v", err)
+	}
+	if g, w := string(object.Values[1].Bytes()), "Don't talk to me about life!"; g != w {
+		t.Errorf("part 1 = %q, want %q", g, w)
+	}
+
+	corrupted := bytes.Replace(b.Bytes(), []byte("Life?"), []byte("Life!"), 1)
+	_, err = NewReader(bytes.NewReader(corrupted), params).ReadObject()
+	var perr *PartError
+	if !errors.Is(err, ErrChecksum) || !errors.As(err, &perr) || perr.Part != 0 {
+		t.Errorf("ReadObject = %v, want %v in part 0", err, ErrChecksum)
+	}
+}
+
+func TestVerifier(t *testing.T) {
+	tests := []struct {
+		header map[string]string
+		ok     bool
+	}{
+		{map[string]string{}, true},
+		{map[string]string{"Content-Length": "5"}, true},
+		{map[string]string{"Content-Length": "6"}, false},
+		{map[string]string{"Content-Length": "five"}, false},
+		{map[string]string{"Content-MD5": "aamXw9Tusa4UPXHukcB2Ng=="}, true},
+		{map[string]string{"Content-MD5": "1B2M2Y8AsgTpgAmY7PhCfg=="}, false},
+		{map[string]string{"Content-MD5": "not base64"}, false},
+		{map[string]string{"Content-Digest": "md5=:1B2M2Y8AsgTpgAmY7PhCfg==:"}, true},
+		{map[string]string{"Content-Digest": "sha-256=:47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=:"}, false},
+		{map[string]string{"Content-Digest": "SHA-256=47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU="}, false},
+	}
+	for i, tt := range tests {
+		h := make(textproto.MIMEHeader)
+		for k, v := range tt.header {
+			h.Set(k, v)
+		}
+		var r io.Reader = strings.NewReader("Life?")
+		if v := newVerifier(h); v != nil {
+			v.r = v.count(r)
+			r = v
+		}
+		_, err := ioutil.ReadAll(r)
+		if tt.ok && err != nil || !tt.ok && !errors.Is(err, ErrChecksum) {
+			t.Errorf("%d. %v: err = %v, want ok = %t", i, tt.header, err, tt.ok)
+		}
+	}
+}
+
+func TestChecksumsRename(t *testing.T) {
+	var b bytes.Buffer
+	w := NewWriter(&b)
+	w.SetChecksums(ContentMD5)
+	root, _ := w.CreateRoot("page@x", "text/html", nil)
+	io.WriteString(root, `<img src="cid:img@x">`)
+	part, _ := w.CreatePart("img@x", textproto.MIMEHeader{"Content-Type": {"image/png"}})
+	io.WriteString(part, "\x89PNG")
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	r, err := NewReaderFromContentType(&b, w.FormDataContentType())
+	if err != nil {
+		t.Fatalf("NewReaderFromContentType: %v", err)
+	}
+	o, err := r.ReadObject()
+	if err != nil {
+		t.Fatalf("ReadObject: %v", err)
+	}
+	if err := o.Rename("img@x", "pic@x"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	if o.Values[0].Header.Get("Content-Md5") != "" {
+		t.Errorf("Content-MD5 of rewritten root kept")
+	}
+	if o.Values[1].Header.Get("Content-Md5") == "" {
+		t.Errorf("Content-MD5 of unchanged part dropped")
+	}
+
+	b.Reset()
+	if _, err := o.WriteTo(&b); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	r, err = NewReaderFromContentType(&b, o.ContentType())
+	if err != nil {
+		t.Fatalf("NewReaderFromContentType: %v", err)
+	}
+	o, err = r.ReadObject()
+	if err != nil {
+		t.Fatalf("ReadObject of renamed: %v", err)
+	}
+	if g, w := string(o.Values[0].Bytes()), `<img src="cid:pic@x">`; g != w {
+		t.Errorf("root = %q, want %q", g, w)
+	}
+}
+
+func TestChecksumsEncodings(t *testing.T) {
+	for _, tt := range []struct {
+		cte, body string
+		ok        bool
+	}{
+		{"quoted-printable", "Life=3F", true},
+		{"Base64", "TGlmZT8=", true},
+		{"7bit", "Life?", true},
+		{"x-uuencode", "Life?", false},
+	} {
+		var b bytes.Buffer
+		w := NewWriter(&b)
+		w.SetChecksums(ContentLength | ContentMD5)
+		part, _ := w.CreatePart("a@b.c", textproto.MIMEHeader{
+			"Content-Type":              {"text/plain"},
+			"Content-Transfer-Encoding": {tt.cte},
+		})
+		io.WriteString(part, tt.body)
+		err := w.Close()
+		if !tt.ok {
+			if err == nil {
+				t.Errorf("%s: Close accepted", tt.cte)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: Close: %v", tt.cte, err)
+		}
+		r, _ := NewReaderFromContentType(&b, w.FormDataContentType())
+		object, err := r.ReadObject()
+		if err != nil {
+			t.Errorf("%s: ReadObject: %v", tt.cte, err)
+			continue
+		}
+		if g := string(object.Values[0].Bytes()); g != "Life?" {
+			t.Errorf("%s: content = %q, want Life?", tt.cte, g)
+		}
+	}
+}
+
+func TestChecksumsEncodedLength(t *testing.T) {
+	tests := []struct {
+		header string
+		ignore bool
+		ok     bool
+	}{
+		{"Content-Length: 8\r\n", false, true},
+		{"Content-Length: 5\r\n", false, false},
+		{"Content-MD5: aamXw9Tusa4UPXHukcB2Ng==\r\n", false, true},
+		{"Content-Length: 5\r\nContent-MD5: 1B2M2Y8AsgTpgAmY7PhCfg==\r\n", true, true},
+	}
+	for _, tt := range tests {
+		body := "--b\r\nContent-Transfer-Encoding: base64\r\n" + tt.header +
+			"\r\nTGlmZT8=\r\n--b--\r\n"
+		r := NewReader(strings.NewReader(body), map[string]string{"boundary": "b"})
+		r.IgnoreChecksums = tt.ignore
+		object, err := r.ReadObject()
+		if !tt.ok {
+			if !errors.Is(err, ErrChecksum) {
+				t.Errorf("%q: ReadObject = %v, want %v", tt.header, err, ErrChecksum)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: ReadObject: %v", tt.header, err)
+			continue
+		}
+		if g := string(object.Values[0].Bytes()); g != "Life?" {
+			t.Errorf("%q: content = %q, want Life?", tt.header, g)
+		}
+	}
+}
@@ -0,0 +1,344 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package related
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/textproto"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// PartMarshaler is the interface implemented by types that encode
+// themselves as a part. The returned header may carry a Content-Type;
+// Content-ID is assigned by the caller.
+type PartMarshaler interface {
+	MarshalPart() (textproto.MIMEHeader, io.Reader, error)
+}
+
+// PartUnmarshaler is the interface implemented by types that decode
+// themselves from a part.
+type PartUnmarshaler interface {
+	UnmarshalPart(*Part) error
+}
+
+// A Codec converts values from and to part bodies of a media type.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+var (
+	marshalerType   = reflect.TypeOf((*PartMarshaler)(nil)).Elem()
+	unmarshalerType = reflect.TypeOf((*PartUnmarshaler)(nil)).Elem()
+)
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{
+		"application/json":         jsonCodec{},
+		"application/xml":          xmlCodec{},
+		"text/xml":                 xmlCodec{},
+		"application/protobuf":     bytesCodec{},
+		"application/x-protobuf":   bytesCodec{},
+		"application/octet-stream": bytesCodec{},
+		"text/*":                   textCodec{},
+	}
+)
+
+// RegisterCodec makes c available for the given media type, replacing any
+// codec registered before. A media type of "major/*" registers c for all
+// subtypes without a codec of their own.
+func RegisterCodec(mediaType string, c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[strings.ToLower(mediaType)] = c
+}
+
+// lookupCodec returns the codec of mediaType. Structured syntax suffixes,
+// e.g. "+json", fall back to the codec of application/<suffix>.
+func lookupCodec(mediaType string) (Codec, bool) {
+	t, _, err := mime.ParseMediaType(mediaType)
+	if err != nil {
+		return nil, false
+	}
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+
+	if c, ok := codecs[t]; ok {
+		return c, true
+	}
+	if i := strings.LastIndex(t, "+"); i >= 0 {
+		if c, ok := codecs["application/"+t[i+1:]]; ok {
+			return c, true
+		}
+	}
+	if i := strings.Index(t, "/"); i >= 0 {
+		if c, ok := codecs[t[:i]+"/*"]; ok {
+			return c, true
+		}
+	}
+	return nil, false
+}
+
+// EncodeRoot writes v as the compound object's root. See EncodePart.
+func (w *Writer) EncodeRoot(contentId, mediaType string, v interface{}) error {
+	header, body, err := encodeValue(reflect.ValueOf(v), mediaType)
+	if err != nil {
+		return err
+	}
+	pw, err := w.CreateRoot(contentId, header.Get("Content-Type"), header)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(pw, body)
+	return err
+}
+
+// EncodePart writes v as a new part. If v implements PartMarshaler its
+// MarshalPart is used, otherwise v is encoded with the codec registered
+// for the Content-Type of header. Fields set in header take precedence.
+func (w *Writer) EncodePart(
+	contentId string,
+	header textproto.MIMEHeader,
+	v interface{},
+) error {
+	h, body, err := encodeValue(reflect.ValueOf(v), header.Get("Content-Type"))
+	if err != nil {
+		return err
+	}
+	for k, vv := range header {
+		h[k] = vv
+	}
+	pw, err := w.CreatePart(contentId, h)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(pw, body)
+	return err
+}
+
+// Decode reads the part's body into v, which must be a non-nil pointer.
+// If v implements PartUnmarshaler its UnmarshalPart is used, otherwise
+// the codec registered for the part's Content-Type.
+func (p *Part) Decode(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() {
+		return ErrInvalidTarget
+	}
+	if u, ok := v.(PartUnmarshaler); ok {
+		return u.UnmarshalPart(p)
+	}
+	return decodeValue(rv.Elem(), p, p.Header.Get("Content-Type"))
+}
+
+// encodeValue returns the header and body of a part holding v. mediaType,
+// if set, overrides the media type v provides on its own.
+func encodeValue(v reflect.Value, mediaType string) (textproto.MIMEHeader, io.Reader, error) {
+	if !v.IsValid() {
+		return nil, nil, fmt.Errorf("%w <nil>", ErrUnsupportedType)
+	}
+	header := make(textproto.MIMEHeader)
+	if m, ok := asMarshaler(v); ok {
+		h, body, err := m.MarshalPart()
+		if err != nil {
+			return nil, nil, err
+		}
+		for k, vv := range h {
+			header[k] = vv
+		}
+		if mediaType != "" {
+			header.Set("Content-Type", mediaType)
+		}
+		return header, body, nil
+	}
+
+	if mediaType == "" {
+		mediaType = defaultMediaTypeOf(v.Type())
+	}
+	header.Set("Content-Type", mediaType)
+
+	switch {
+	case v.Type() == bytesType:
+		return header, bytes.NewReader(v.Bytes()), nil
+	case v.Kind() == reflect.String:
+		return header, strings.NewReader(v.String()), nil
+	case v.Type().Implements(readerType):
+		if isNil(v) {
+			return header, strings.NewReader(""), nil
+		}
+		return header, v.Interface().(io.Reader), nil
+	}
+
+	c, ok := lookupCodec(mediaType)
+	if !ok {
+		return nil, nil, fmt.Errorf("%w %s as %s", ErrUnsupportedType, v.Type(), mediaType)
+	}
+	b, err := c.Marshal(v.Interface())
+	if err != nil {
+		return nil, nil, err
+	}
+	return header, bytes.NewReader(b), nil
+}
+
+// decodeValue stores the body of p, of the given media type, in v. v must
+// be addressable.
+func decodeValue(v reflect.Value, p *Part, mediaType string) error {
+	if u, ok := asUnmarshaler(v); ok {
+		return u.UnmarshalPart(p)
+	}
+	if v.Type() == readerType {
+		v.Set(reflect.ValueOf(io.Reader(p)))
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(p)
+	if err != nil {
+		return err
+	}
+	switch {
+	case v.Type() == bytesType:
+		v.SetBytes(body)
+		return nil
+	case v.Kind() == reflect.String:
+		v.SetString(string(body))
+		return nil
+	case v.Type().Implements(readerType):
+		r, ok := bodyReader(v.Type(), body)
+		if !ok {
+			return fmt.Errorf("%w %s", ErrUnsupportedType, v.Type())
+		}
+		v.Set(r)
+		return nil
+	}
+
+	c, ok := lookupCodec(mediaType)
+	if !ok {
+		return fmt.Errorf("%w %s as %s", ErrUnsupportedType, v.Type(), mediaType)
+	}
+	return c.Unmarshal(body, v.Addr().Interface())
+}
+
+// bodyReader returns a reader of body assignable to t, which is one of
+// io.Reader's implementations encodeValue streams from.
+func bodyReader(t reflect.Type, body []byte) (reflect.Value, bool) {
+	for _, r := range []interface{}{
+		bytes.NewReader(body),
+		bytes.NewBuffer(body),
+		strings.NewReader(string(body)),
+	} {
+		if rv := reflect.ValueOf(r); rv.Type().AssignableTo(t) {
+			return rv, true
+		}
+	}
+	return reflect.Value{}, false
+}
+
+func asMarshaler(v reflect.Value) (PartMarshaler, bool) {
+	if v.Type().Implements(marshalerType) {
+		if isNil(v) {
+			return nil, false
+		}
+		return v.Interface().(PartMarshaler), true
+	}
+	if v.CanAddr() && v.Addr().Type().Implements(marshalerType) {
+		return v.Addr().Interface().(PartMarshaler), true
+	}
+	return nil, false
+}
+
+func asUnmarshaler(v reflect.Value) (PartUnmarshaler, bool) {
+	if v.Kind() == reflect.Ptr && v.Type().Implements(unmarshalerType) {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return v.Interface().(PartUnmarshaler), true
+	}
+	if v.CanAddr() && v.Addr().Type().Implements(unmarshalerType) {
+		return v.Addr().Interface().(PartUnmarshaler), true
+	}
+	return nil, false
+}
+
+func isNil(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice:
+		return v.IsNil()
+	}
+	return false
+}
+
+// jsonCodec encodes values with encoding/json.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// xmlCodec encodes values with encoding/xml.
+type xmlCodec struct{}
+
+func (xmlCodec) Marshal(v interface{}) ([]byte, error) {
+	return xml.Marshal(v)
+}
+
+func (xmlCodec) Unmarshal(data []byte, v interface{}) error {
+	return xml.Unmarshal(data, v)
+}
+
+// textCodec handles encoding.TextMarshaler and fmt.Stringer values.
+type textCodec struct{}
+
+func (textCodec) Marshal(v interface{}) ([]byte, error) {
+	switch t := v.(type) {
+	case encoding.TextMarshaler:
+		return t.MarshalText()
+	case fmt.Stringer:
+		return []byte(t.String()), nil
+	}
+	return nil, fmt.Errorf("%w %T as text", ErrUnsupportedType, v)
+}
+
+func (textCodec) Unmarshal(data []byte, v interface{}) error {
+	if t, ok := v.(encoding.TextUnmarshaler); ok {
+		return t.UnmarshalText(data)
+	}
+	return fmt.Errorf("%w %T as text", ErrUnsupportedType, v)
+}
+
+// bytesCodec passes bodies through values which marshal themselves to
+// bytes, such as protocol buffer messages.
+type bytesCodec struct{}
+
+func (bytesCodec) Marshal(v interface{}) ([]byte, error) {
+	if m, ok := v.(interface {
+		Marshal() ([]byte, error)
+	}); ok {
+		return m.Marshal()
+	}
+	return nil, fmt.Errorf("%w %T as bytes", ErrUnsupportedType, v)
+}
+
+func (bytesCodec) Unmarshal(data []byte, v interface{}) error {
+	if u, ok := v.(interface {
+		Unmarshal([]byte) error
+	}); ok {
+		return u.Unmarshal(data)
+	}
+	return fmt.Errorf("%w %T as bytes", ErrUnsupportedType, v)
+}
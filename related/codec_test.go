@@ -0,0 +1,184 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package related
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+// testPoint encodes itself as "x,y".
+type testPoint struct {
+	X, Y string
+}
+
+func (p testPoint) MarshalPart() (textproto.MIMEHeader, io.Reader, error) {
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Type", "application/x-point")
+	return h, strings.NewReader(p.X + "," + p.Y), nil
+}
+
+func (p *testPoint) UnmarshalPart(part *Part) error {
+	b, err := ioutil.ReadAll(part)
+	if err != nil {
+		return err
+	}
+	s := strings.SplitN(string(b), ",", 2)
+	if len(s) != 2 {
+		return errors.New("malformed point")
+	}
+	p.X, p.Y = s[0], s[1]
+	return nil
+}
+
+// testProto mimics a protocol buffer message.
+type testProto struct {
+	b []byte
+}
+
+func (m *testProto) Marshal() ([]byte, error) { return m.b, nil }
+
+func (m *testProto) Unmarshal(b []byte) error {
+	m.b = append([]byte(nil), b...)
+	return nil
+}
+
+func TestEncodeDecodePart(t *testing.T) {
+	var b bytes.Buffer
+	w := NewWriter(&b)
+
+	if err := w.EncodeRoot("a@b.c", "application/json", map[string]int{"answer": 42}); err != nil {
+		t.Fatalf("EncodeRoot: %v", err)
+	}
+	if err := w.EncodePart("b@c.d", nil, testPoint{"1", "2"}); err != nil {
+		t.Fatalf("EncodePart point: %v", err)
+	}
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Type", "application/x-protobuf")
+	if err := w.EncodePart("c@d.e", h, &testProto{[]byte{1, 2, 3}}); err != nil {
+		t.Fatalf("EncodePart proto: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	_, params, err := mime.ParseMediaType(w.FormDataContentType())
+	if err != nil {
+		t.Fatalf("ParseMediaType: %v", err)
+	}
+	r := NewReader(&b, params)
+
+	p, err := r.NextPart()
+	if err != nil {
+		t.Fatalf("part root: %v", err)
+	}
+	var root map[string]int
+	if err := p.Decode(&root); err != nil {
+		t.Fatalf("part root: Decode: %v", err)
+	}
+	if root["answer"] != 42 {
+		t.Errorf("part root = %v, want answer 42", root)
+	}
+
+	p, err = r.NextPart()
+	if err != nil {
+		t.Fatalf("part 2: %v", err)
+	}
+	if g, w := p.Header.Get("Content-Type"), "application/x-point"; g != w {
+		t.Errorf("part 2: Content-Type = %s, want %s", g, w)
+	}
+	var pt testPoint
+	if err := p.Decode(&pt); err != nil {
+		t.Fatalf("part 2: Decode: %v", err)
+	}
+	if pt != (testPoint{"1", "2"}) {
+		t.Errorf("part 2 = %+v, want {1 2}", pt)
+	}
+
+	p, err = r.NextPart()
+	if err != nil {
+		t.Fatalf("part 3: %v", err)
+	}
+	var m testProto
+	if err := p.Decode(&m); err != nil {
+		t.Fatalf("part 3: Decode: %v", err)
+	}
+	if !bytes.Equal(m.b, []byte{1, 2, 3}) {
+		t.Errorf("part 3 = %v, want [1 2 3]", m.b)
+	}
+}
+
+func TestMarshalPartMarshaler(t *testing.T) {
+	type doc struct {
+		Root  string     `related:"root,type=text/plain"`
+		Point testPoint  `related:"cid=point@x"`
+		Ptr   *testPoint `related:"cid=ptr@x"`
+	}
+	in := doc{"root", testPoint{"a", "b"}, &testPoint{"c", "d"}}
+	body, contentType, err := Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	_, params, _ := mime.ParseMediaType(contentType)
+
+	var out doc
+	if err := Unmarshal(bytes.NewReader(body), params, &out); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if out.Point != in.Point || out.Ptr == nil || *out.Ptr != *in.Ptr {
+		t.Errorf("Unmarshal = %+v, want %+v", out, in)
+	}
+}
+
+func TestLookupCodec(t *testing.T) {
+	tests := []struct {
+		mediaType string
+		codec     Codec
+	}{
+		{"application/json", jsonCodec{}},
+		{"application/json; charset=utf-8", jsonCodec{}},
+		{"application/ld+json", jsonCodec{}},
+		{"application/atom+xml", xmlCodec{}},
+		{"text/xml", xmlCodec{}},
+		{"text/csv", textCodec{}},
+		{"application/x-protobuf", bytesCodec{}},
+		{"image/png", nil},
+		{";", nil},
+	}
+	for i, tt := range tests {
+		c, ok := lookupCodec(tt.mediaType)
+		if ok != (tt.codec != nil) || c != tt.codec {
+			t.Errorf("%d. lookupCodec(%s) = %T, want %T", i, tt.mediaType, c, tt.codec)
+		}
+	}
+
+	RegisterCodec("image/*", bytesCodec{})
+	defer func() {
+		codecsMu.Lock()
+		delete(codecs, "image/*")
+		codecsMu.Unlock()
+	}()
+	if c, _ := lookupCodec("image/png"); c != (bytesCodec{}) {
+		t.Errorf("lookupCodec(image/png) = %T after RegisterCodec", c)
+	}
+}
+
+func TestDecodeUnsupported(t *testing.T) {
+	p := &Part{Header: textproto.MIMEHeader{"Content-Type": {"image/png"}}, r: strings.NewReader("")}
+	var v struct{}
+	if err := p.Decode(&v); !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("Decode = %v, want %v", err, ErrUnsupportedType)
+	}
+	if err := p.Decode(v); err != ErrInvalidTarget {
+		t.Errorf("Decode = %v, want %v", err, ErrInvalidTarget)
+	}
+}
@@ -0,0 +1,173 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package related
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+)
+
+// ErrBoundaryCollision is returned if the boundary occurs in part data,
+// which would corrupt the message.
+var ErrBoundaryCollision = errors.New("boundary occurs in part data")
+
+// A CollisionPolicy tells a Writer how to deal with part data containing
+// a delimiter line.
+type CollisionPolicy int
+
+const (
+	// CollisionIgnore doesn't scan part data. It's the default.
+	CollisionIgnore CollisionPolicy = iota
+
+	// CollisionRefuse fails writes of part data containing a delimiter
+	// line with ErrBoundaryCollision.
+	CollisionRefuse
+
+	// CollisionReselect buffers all parts in memory until Close, and
+	// picks a boundary occurring in none of them. A boundary set with
+	// SetBoundary or SetDeterministic is kept if it's safe.
+	CollisionReselect
+)
+
+// SetCollisionPolicy makes the Writer scan part data for the boundary.
+// Unless p is CollisionIgnore, Close also counts the delimiter lines
+// written, returning ErrBoundaryCollision if there are more than parts.
+// SetCollisionPolicy must be called before any parts are created.
+func (w *Writer) SetCollisionPolicy(p CollisionPolicy) error {
+	if w.n > 0 {
+		return errors.New("SetCollisionPolicy called after parts were created")
+	}
+	w.collisions = p
+	if p == CollisionReselect {
+		w.deferAll = true
+	}
+	if p != CollisionIgnore && w.delims == nil {
+		w.delims = &delimCounter{w: w.cw.w, boundary: w.Boundary}
+		w.cw.w = w.delims
+	}
+	return nil
+}
+
+// selectBoundary sets the boundary of the deferred parts before they are
+// written: derived from their content in deterministic mode, and
+// reselected until it occurs in none of them with CollisionReselect.
+func (w *Writer) selectBoundary() error {
+	boundary := w.Boundary()
+	if w.deriveBoundary {
+		boundary = w.contentBoundary()
+	}
+	for w.collisions == CollisionReselect && w.collides(boundary) {
+		if w.deterministic {
+			h := sha256.New()
+			h.Write([]byte("reselect\x00" + boundary))
+			boundary = hashBoundary(h)
+		} else {
+			boundary = multipart.NewWriter(nil).Boundary()
+		}
+	}
+	return w.SetBoundary(boundary)
+}
+
+// collides reports whether a delimiter line of boundary occurs in any
+// deferred part.
+func (w *Writer) collides(boundary string) bool {
+	for _, p := range w.deferred {
+		s := delimScanner{pattern: []byte("\n--" + boundary), tail: []byte("\n")}
+		if s.scan(p.body.Bytes()) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// selfCheck verifies the number of delimiter lines written after Close.
+func (w *Writer) selfCheck() error {
+	if w.delims == nil {
+		return nil
+	}
+	if want := w.n + 1; w.delims.count != want {
+		return fmt.Errorf("%w: found %d delimiter lines, want %d", ErrBoundaryCollision, w.delims.count, want)
+	}
+	return nil
+}
+
+// delimScanner counts occurrences of pattern in data written in chunks.
+// A delimiter line starts after a line break, so pattern is "\n--" plus
+// the boundary, ignoring what follows the boundary.
+type delimScanner struct {
+	pattern []byte
+
+	// tail holds the last len(pattern)-1 bytes scanned
+	tail []byte
+}
+
+// scan returns the occurrences of pattern ending in p.
+func (s *delimScanner) scan(p []byte) int {
+	k := len(s.pattern) - 1
+	junction := append(append([]byte(nil), s.tail...), p[:min(len(p), k)]...)
+	n := bytes.Count(junction, s.pattern) + bytes.Count(p, s.pattern)
+
+	if len(junction) > k {
+		junction = junction[len(junction)-k:]
+	}
+	if len(p) >= k {
+		junction = append(junction[:0], p[len(p)-k:]...)
+	}
+	s.tail = junction
+	return n
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// collisionWriter refuses part data containing a delimiter line.
+type collisionWriter struct {
+	w    io.Writer
+	s    delimScanner
+	part PartError
+}
+
+func newCollisionWriter(w io.Writer, boundary string, part PartError) *collisionWriter {
+	// Part data follows the line break ending the part's header.
+	s := delimScanner{pattern: []byte("\n--" + boundary), tail: []byte("\n")}
+	return &collisionWriter{w: w, s: s, part: part}
+}
+
+func (c *collisionWriter) Write(p []byte) (int, error) {
+	if c.s.scan(p) > 0 {
+		err := c.part
+		err.Err = ErrBoundaryCollision
+		return 0, &err
+	}
+	return c.w.Write(p)
+}
+
+// delimCounter counts the delimiter lines of a message written through
+// it, for the self-check.
+type delimCounter struct {
+	w        io.Writer
+	boundary func() string
+	s        *delimScanner
+	count    int
+}
+
+func (d *delimCounter) Write(p []byte) (int, error) {
+	if d.s == nil {
+		// The boundary is final once the message is written, and the
+		// first delimiter line may start the stream.
+		d.s = &delimScanner{pattern: []byte("\n--" + d.boundary()), tail: []byte("\n")}
+	}
+	d.count += d.s.scan(p)
+	return d.w.Write(p)
+}
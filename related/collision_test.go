@@ -0,0 +1,140 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package related
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestDelimScanner(t *testing.T) {
+	tests := []struct {
+		chunks []string
+		want   int
+	}{
+		{[]string{"--b"}, 1},
+		{[]string{"a--b"}, 0},
+		{[]string{"a\r\n--b--"}, 1},
+		{[]string{"a\r", "\n-", "-", "b"}, 1},
+		{[]string{"--b\n--b", "\n--", "b"}, 3},
+		{[]string{"", "-", "-b"}, 1},
+	}
+	for _, test := range tests {
+		s := delimScanner{pattern: []byte("\n--b"), tail: []byte("\n")}
+		n := 0
+		for _, c := range test.chunks {
+			n += s.scan([]byte(c))
+		}
+		if n != test.want {
+			t.Errorf("%q: %d delimiters, want %d", test.chunks, n, test.want)
+		}
+	}
+}
+
+func TestCollisionRefuse(t *testing.T) {
+	for _, checksums := range []Checksum{0, ContentLength} {
+		w := NewWriter(&bytes.Buffer{})
+		w.SetBoundary("b")
+		w.SetChecksums(checksums)
+		if err := w.SetCollisionPolicy(CollisionRefuse); err != nil {
+			t.Fatalf("SetCollisionPolicy: %v", err)
+		}
+		root, err := w.CreateRoot("a@b.c", "text/plain", nil)
+		if err != nil {
+			t.Fatalf("CreateRoot: %v", err)
+		}
+		if _, err := io.WriteString(root, "Life?\r\n"); err != nil {
+			t.Errorf("checksums %d: Write = %v", checksums, err)
+		}
+		_, err = io.WriteString(root, "--b\r\n")
+		if checksums == 0 {
+			if !errors.Is(err, ErrBoundaryCollision) {
+				t.Errorf("Write = %v, want %v", err, ErrBoundaryCollision)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("buffered Write = %v", err)
+		}
+		err = w.Close()
+		var perr *PartError
+		if !errors.As(err, &perr) || perr.Part != 0 || perr.ContentId != "a@b.c" ||
+			!errors.Is(err, ErrBoundaryCollision) {
+			t.Errorf("Close = %v, want collision in part 0", err)
+		}
+	}
+}
+
+func TestCollisionReselect(t *testing.T) {
+	for _, deterministic := range []bool{false, true} {
+		var b bytes.Buffer
+		w := NewWriter(&b)
+		w.SetBoundary("b")
+		if deterministic {
+			w.SetDeterministic([]byte("Marvin"))
+		}
+		if err := w.SetCollisionPolicy(CollisionReselect); err != nil {
+			t.Fatalf("SetCollisionPolicy: %v", err)
+		}
+		boundary := w.Boundary()
+		content := "Life?\r\n--" + boundary + "\r\nDon't talk to me about life!"
+		root, err := w.CreateRoot("a@b.c", "text/plain", nil)
+		if err != nil {
+			t.Fatalf("CreateRoot: %v", err)
+		}
+		io.WriteString(root, content)
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+		if w.Boundary() == boundary {
+			t.Errorf("deterministic %t: boundary %q kept", deterministic, boundary)
+		}
+
+		r, err := NewReaderFromContentType(&b, w.FormDataContentType())
+		if err != nil {
+			t.Fatalf("NewReaderFromContentType: %v", err)
+		}
+		object, err := r.ReadObject()
+		if err != nil {
+			t.Fatalf("ReadObject: %v", err)
+		}
+		if g := string(object.Lookup("a@b.c").Bytes()); g != content {
+			t.Errorf("root = %q, want %q", g, content)
+		}
+	}
+
+	w := NewWriter(&bytes.Buffer{})
+	w.SetBoundary("b")
+	w.SetCollisionPolicy(CollisionReselect)
+	part, _ := w.CreatePart("", nil)
+	io.WriteString(part, "Life?")
+	if err := w.Close(); err != nil || w.Boundary() != "b" {
+		t.Errorf("Close = %v with boundary %q, want b kept", err, w.Boundary())
+	}
+}
+
+func TestCollisionSelfCheck(t *testing.T) {
+	var b bytes.Buffer
+	w := NewWriter(&b)
+	w.SetBoundary("b")
+	w.SetCollisionPolicy(CollisionRefuse)
+	w.CreatePart("", nil)
+	// Write around the part writer, as if a part was written unscanned.
+	io.WriteString(w.cw, "\r\n--b\r\n")
+	if err := w.Close(); !errors.Is(err, ErrBoundaryCollision) ||
+		!strings.Contains(err.Error(), "found 3 delimiter lines, want 2") {
+		t.Errorf("Close = %v, want self-check failure", err)
+	}
+
+	w = NewWriter(&bytes.Buffer{})
+	w.CreatePart("", nil)
+	if err := w.SetCollisionPolicy(CollisionRefuse); err == nil {
+		t.Errorf("SetCollisionPolicy accepted after parts were created")
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package related
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/textproto"
+	"sort"
+)
+
+// headerCasing maps canonical MIME header keys to the casing of their
+// RFCs, where it differs.
+var headerCasing = map[string]string{
+	"Content-Id":   "Content-ID",
+	"Content-Md5":  "Content-MD5",
+	"Mime-Version": "MIME-Version",
+	"Message-Id":   "Message-ID",
+}
+
+// SetDeterministic makes the Writer's output depend on its input only, so
+// that identical inputs produce byte-identical messages. Header fields are
+// written sorted, in the casing of their RFCs, e.g. Content-ID.
+//
+// The boundary is derived from seed. If seed is nil, it's derived from
+// the parts' headers and content instead, which requires buffering all
+// parts in memory until Close; FormDataContentType is final only after
+// Close then. SetDeterministic must be called before any parts are
+// created.
+func (w *Writer) SetDeterministic(seed []byte) error {
+	if w.n > 0 {
+		return errors.New("SetDeterministic called after parts were created")
+	}
+	w.deterministic = true
+	if seed == nil {
+		w.deriveBoundary = true
+		w.deferAll = true
+		return nil
+	}
+	h := sha256.New()
+	h.Write([]byte("boundary\x00"))
+	h.Write(seed)
+	return w.SetBoundary(hashBoundary(h))
+}
+
+// hashBoundary formats the sum of h as boundary.
+func hashBoundary(h hash.Hash) string {
+	return hex.EncodeToString(h.Sum(nil))[:40]
+}
+
+// contentBoundary derives the boundary from the deferred parts.
+func (w *Writer) contentBoundary() string {
+	h := sha256.New()
+	h.Write([]byte("content\x00"))
+	for _, p := range w.deferred {
+		header := canonicalCasing(p.header)
+		keys := make([]string, 0, len(header))
+		for k := range header {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			for _, v := range header[k] {
+				fmt.Fprintf(h, "%s: %s\r\n", k, v)
+			}
+		}
+		fmt.Fprintf(h, "\r\n%d\r\n", p.body.Len())
+		h.Write(p.body.Bytes())
+	}
+	return hashBoundary(h)
+}
+
+// canonicalCasing returns a copy of header with keys in the casing of
+// their RFCs.
+func canonicalCasing(header textproto.MIMEHeader) textproto.MIMEHeader {
+	h := make(textproto.MIMEHeader, len(header))
+	for k, v := range header {
+		k = textproto.CanonicalMIMEHeaderKey(k)
+		if c, ok := headerCasing[k]; ok {
+			k = c
+		}
+		h[k] = append(h[k], v...)
+	}
+	return h
+}
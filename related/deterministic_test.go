@@ -0,0 +1,91 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package related
+
+import (
+	"bytes"
+	"io"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+func writeDeterministic(t *testing.T, seed []byte, content string) ([]byte, string) {
+	var b bytes.Buffer
+	w := NewWriter(&b)
+	if err := w.SetDeterministic(seed); err != nil {
+		t.Fatalf("SetDeterministic: %v", err)
+	}
+	if err := w.SetChecksums(ContentMD5); err != nil {
+		t.Fatalf("SetChecksums: %v", err)
+	}
+	if err := w.SetMessageHeader(textproto.MIMEHeader{"Message-Id": {"<m@x>"}}); err != nil {
+		t.Fatalf("SetMessageHeader: %v", err)
+	}
+	root, err := w.CreateRoot("a@b.c", "text/html", textproto.MIMEHeader{"content-location": {"index.html"}})
+	if err != nil {
+		t.Fatalf("CreateRoot: %v", err)
+	}
+	io.WriteString(root, content)
+	part, err := w.CreatePart("b@c.d", nil)
+	if err != nil {
+		t.Fatalf("CreatePart: %v", err)
+	}
+	io.WriteString(part, "Don't talk to me about life!")
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return b.Bytes(), w.FormDataContentType()
+}
+
+func TestDeterministic(t *testing.T) {
+	for _, seed := range [][]byte{nil, []byte("Marvin")} {
+		b1, ct1 := writeDeterministic(t, seed, "Life?")
+		b2, ct2 := writeDeterministic(t, seed, "Life?")
+		if !bytes.Equal(b1, b2) || ct1 != ct2 {
+			t.Errorf("seed %q: outputs differ:\n%q\n%q", seed, b1, b2)
+		}
+
+		for _, want := range []string{
+			"MIME-Version: 1.0\r\n",
+			"Message-ID: <m@x>\r\n",
+			"Content-ID: <a@b.c>\r\nContent-Location: index.html\r\nContent-MD5: ",
+		} {
+			if !bytes.Contains(b1, []byte(want)) {
+				t.Errorf("seed %q: output = %q, want %q", seed, b1, want)
+			}
+		}
+
+		r, err := NewMessageReader(bytes.NewReader(b1))
+		if err != nil {
+			t.Fatalf("NewMessageReader: %v", err)
+		}
+		object, err := r.ReadObject()
+		if err != nil {
+			t.Fatalf("ReadObject: %v", err)
+		}
+		if g, w := string(object.Lookup("a@b.c").Bytes()), "Life?"; g != w {
+			t.Errorf("root = %q, want %q", g, w)
+		}
+
+		_, ct3 := writeDeterministic(t, seed, "Life!")
+		if changed := ct3 != ct1; changed != (seed == nil) {
+			t.Errorf("seed %q: boundary changed with content = %t", seed, changed)
+		}
+	}
+
+	_, ct1 := writeDeterministic(t, []byte("Marvin"), "Life?")
+	_, ct2 := writeDeterministic(t, []byte("Arthur"), "Life?")
+	if ct1 == ct2 || !strings.Contains(ct1, "boundary=") {
+		t.Errorf("boundaries of different seeds: %s, %s", ct1, ct2)
+	}
+
+	w := NewWriter(&bytes.Buffer{})
+	w.CreatePart("", nil)
+	if err := w.SetDeterministic(nil); err == nil {
+		t.Errorf("SetDeterministic accepted after parts were created")
+	}
+}
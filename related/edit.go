@@ -0,0 +1,258 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package related
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/textproto"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Errors introduced by editing an Object.
+var (
+	ErrNotFound      = errors.New("part not found")
+	ErrDupContentId  = errors.New("duplicate content-ID")
+	ErrNoRoot        = errors.New("compound object has no root")
+	ErrDanglingRef   = errors.New("reference to missing part")
+	ErrContentIdless = errors.New("root without content-ID must come first")
+)
+
+// Lookup returns the part with the given Content-ID, or nil.
+func (o *Object) Lookup(contentId string) *ObjectHeader {
+	cid := parseContentId(contentId)
+	if cid == "" {
+		return nil
+	}
+	for _, oh := range o.Values {
+		if oh.ContentId() == cid {
+			return oh
+		}
+	}
+	return nil
+}
+
+// ContentId returns the Content-ID of a ObjectHeader without angle
+// brackets, or an empty string if it has none.
+func (oh *ObjectHeader) ContentId() string {
+	return parseContentId(oh.Header.Get("Content-Id"))
+}
+
+// Add appends a new part with the given Content-ID, header and content.
+// If header is nil, a text/plain part is added.
+func (o *Object) Add(
+	contentId string,
+	header textproto.MIMEHeader,
+	content []byte,
+) (*ObjectHeader, error) {
+	if header == nil {
+		header = make(textproto.MIMEHeader)
+		header.Set("Content-Type", DefaultMediaType)
+	}
+	if contentId != "" {
+		if o.Lookup(contentId) != nil {
+			return nil, ErrDupContentId
+		}
+		cid, err := formatContentId(contentId)
+		if err != nil {
+			return nil, err
+		}
+		header.Set("Content-ID", cid)
+	}
+
+	order := 0
+	for _, oh := range o.Values {
+		if oh.order >= order {
+			order = oh.order + 1
+		}
+	}
+	oh := &ObjectHeader{
+		Header:  header,
+		content: content,
+		order:   order,
+	}
+	o.Values = append(o.Values, oh)
+	return oh, nil
+}
+
+// Replace changes the content of the part with the given Content-ID. If
+// header is not nil, it replaces the part's header, keeping its
+// Content-ID. Otherwise integrity headers, like Content-MD5, are removed
+// from the part's header.
+func (o *Object) Replace(
+	contentId string,
+	header textproto.MIMEHeader,
+	content []byte,
+) error {
+	oh := o.Lookup(contentId)
+	if oh == nil {
+		return ErrNotFound
+	}
+	if header != nil {
+		header.Set("Content-ID", oh.Header.Get("Content-Id"))
+		oh.Header = header
+	} else {
+		dropChecksums(oh.Header)
+	}
+	oh.content = content
+	oh.i = 0
+	oh.raw = nil
+	return nil
+}
+
+// Remove deletes the part with the given Content-ID. Removing the root
+// leaves the object without root until SetRoot is called.
+func (o *Object) Remove(contentId string) error {
+	for i, oh := range o.Values {
+		if oh.ContentId() == parseContentId(contentId) && oh.ContentId() != "" {
+			o.Values = append(o.Values[:i], o.Values[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// SetRoot makes the part with the given Content-ID the compound object's
+// root, updating the start and type parameters.
+func (o *Object) SetRoot(contentId string) error {
+	root := o.Lookup(contentId)
+	if root == nil {
+		return ErrNotFound
+	}
+
+	values := []*ObjectHeader{root}
+	for _, oh := range o.Values {
+		oh.Root = oh == root
+		if oh != root {
+			values = append(values, oh)
+		}
+	}
+	o.Values = values
+
+	if o.Params == nil {
+		o.Params = make(map[string]string)
+	}
+	o.Params["start"] = root.Header.Get("Content-Id")
+	if mediaType, _, err := mime.ParseMediaType(root.Header.Get("Content-Type")); err == nil {
+		o.Params["type"] = mediaType
+	}
+	return nil
+}
+
+// Rename changes a part's Content-ID from old to new and rewrites "cid:"
+// references to it in all textual parts, removing the integrity headers
+// of the parts rewritten.
+func (o *Object) Rename(old, new string) error {
+	oh := o.Lookup(old)
+	if oh == nil {
+		return ErrNotFound
+	}
+	if o.Lookup(new) != nil {
+		return ErrDupContentId
+	}
+	cid, err := formatContentId(new)
+	if err != nil {
+		return err
+	}
+	old, new = parseContentId(old), parseContentId(cid)
+
+	oh.Header.Set("Content-ID", cid)
+	oh.raw = nil
+	if oh.Root && o.Params != nil && o.Params["start"] != "" {
+		o.Params["start"] = cid
+	}
+
+	for _, p := range o.Values {
+		if !isTextual(p.Header.Get("Content-Type")) {
+			continue
+		}
+		content := replaceCid(p.content, old, new)
+		if string(content) != string(p.content) {
+			dropChecksums(p.Header)
+			p.content = content
+			p.i = 0
+			p.raw = nil
+		}
+	}
+	return nil
+}
+
+// Validate checks the compound object before serializing it: there has to
+// be exactly one root, Content-IDs have to be unique and every "cid:"
+// reference found by the registered Extractors has to resolve. Parts an
+// Extractor fails on, e.g. malformed JSON, are skipped.
+func (o *Object) Validate() error {
+	var root *ObjectHeader
+	seen := make(map[string]bool)
+	for _, oh := range o.Values {
+		if oh.Root {
+			if root != nil {
+				return ErrDupRoot
+			}
+			root = oh
+		}
+		if cid := oh.ContentId(); cid != "" {
+			if seen[cid] {
+				return fmt.Errorf("%w: %s", ErrDupContentId, cid)
+			}
+			seen[cid] = true
+		}
+	}
+	if root == nil {
+		return ErrNoRoot
+	}
+	if o.raw != nil && root.ContentId() == "" {
+		for _, oh := range o.Values {
+			if oh.order < root.order {
+				return ErrContentIdless
+			}
+		}
+	}
+
+	g := o.Graph()
+	for _, ref := range g.References {
+		if ref.Dangling() {
+			return fmt.Errorf("%w: %s", ErrDanglingRef, ref.URL)
+		}
+	}
+	return nil
+}
+
+// cidPattern matches "cid:" URLs, see RFC 2392.
+var cidPattern = regexp.MustCompile(`(?i)cid:([^\s"'()<>,;\\]+)`)
+
+// unescapeCid decodes the addr-spec of a "cid:" URL.
+func unescapeCid(s string) string {
+	ref, err := url.PathUnescape(s)
+	if err != nil {
+		return s
+	}
+	return ref
+}
+
+// replaceCid rewrites "cid:" URLs referencing old to reference new.
+func replaceCid(b []byte, old, new string) []byte {
+	return cidPattern.ReplaceAllFunc(b, func(m []byte) []byte {
+		if unescapeCid(string(m[4:])) != old {
+			return m
+		}
+		return append(m[:4:4], url.PathEscape(new)...)
+	})
+}
+
+// isTextual reports whether parts of mediaType may hold references.
+func isTextual(mediaType string) bool {
+	t, _, err := mime.ParseMediaType(mediaType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(t, "text/") ||
+		t == "application/json" || t == "application/xml" ||
+		strings.HasSuffix(t, "+json") || strings.HasSuffix(t, "+xml")
+}
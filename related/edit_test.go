@@ -0,0 +1,171 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package related
+
+import (
+	"bytes"
+	"errors"
+	"net/textproto"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var testHTMLBody = "--example-2\r\n" +
+	"Content-Type: text/html\r\n" +
+	"Content-ID: <page@x>\r\n" +
+	"\r\n" +
+	`<img src="cid:logo@x"><img src="cid:logo@x.y">` + "\r\n" +
+	"--example-2\r\n" +
+	"Content-Type: image/png\r\n" +
+	"Content-ID: <logo@x>\r\n" +
+	"\r\n" +
+	"png\r\n" +
+	"--example-2\r\n" +
+	"Content-Type: image/png\r\n" +
+	"Content-ID: <logo@x.y>\r\n" +
+	"\r\n" +
+	"other png\r\n" +
+	"--example-2--\r\n"
+
+var testHTMLParams = map[string]string{
+	"boundary": "example-2",
+	"start":    "<page@x>",
+	"type":     "text/html",
+}
+
+func readTestObject(t *testing.T, body string, params map[string]string, raw bool) *Object {
+	r := NewReader(strings.NewReader(body), params)
+	r.KeepRaw = raw
+	object, err := r.ReadObject()
+	if err != nil {
+		t.Fatalf("ReadObject: %v", err)
+	}
+	return object
+}
+
+func TestObjectEdit(t *testing.T) {
+	object := readTestObject(t, testHTMLBody, testHTMLParams, true)
+	if err := object.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	if err := object.Rename("logo@x", "marvin@x"); err != nil {
+		t.Fatalf("Rename: %v", err)
+	}
+	want := `<img src="cid:marvin@x"><img src="cid:logo@x.y">`
+	if g := string(object.Lookup("page@x").Bytes()); g != want {
+		t.Errorf("root = %s, want %s", g, want)
+	}
+
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Type", "image/gif")
+	if err := object.Replace("marvin@x", h, []byte("gif")); err != nil {
+		t.Fatalf("Replace: %v", err)
+	}
+	if _, err := object.Add("note@x", nil, []byte("Life?")); err != nil {
+		t.Fatalf("Add: %v", err)
+	}
+	if _, err := object.Add("note@x", nil, nil); err != ErrDupContentId {
+		t.Errorf("Add = %v, want %v", err, ErrDupContentId)
+	}
+
+	var b bytes.Buffer
+	if _, err := object.WriteTo(&b); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	if !strings.Contains(b.String(), "Content-Type: image/png\r\nContent-ID: <logo@x.y>\r\n\r\nother png\r\n") {
+		t.Errorf("WriteTo didn't keep untouched part: %q", b.String())
+	}
+
+	copied := readTestObject(t, b.String(), object.Params, false)
+	var got []string
+	for _, oh := range copied.Values {
+		got = append(got, oh.ContentId()+"="+string(oh.Bytes()))
+	}
+	wantParts := []string{"page@x=" + want, "marvin@x=gif", "logo@x.y=other png", "note@x=Life?"}
+	if !reflect.DeepEqual(got, wantParts) {
+		t.Errorf("parts = %q, want %q", got, wantParts)
+	}
+	if g, w := copied.Lookup("marvin@x").Header.Get("Content-Type"), "image/gif"; g != w {
+		t.Errorf("Content-Type = %s, want %s", g, w)
+	}
+}
+
+func TestObjectSetRoot(t *testing.T) {
+	object := readTestObject(t, testHTMLBody, testHTMLParams, false)
+
+	if err := object.Remove("page@x"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if err := object.Remove("page@x"); err != ErrNotFound {
+		t.Errorf("Remove = %v, want %v", err, ErrNotFound)
+	}
+	if err := object.Validate(); err != ErrNoRoot {
+		t.Errorf("Validate = %v, want %v", err, ErrNoRoot)
+	}
+	if _, err := object.WriteTo(&bytes.Buffer{}); err != ErrNoRoot {
+		t.Errorf("WriteTo = %v, want %v", err, ErrNoRoot)
+	}
+
+	if err := object.SetRoot("logo@x.y"); err != nil {
+		t.Fatalf("SetRoot: %v", err)
+	}
+	if g, w := object.Params["start"], "<logo@x.y>"; g != w {
+		t.Errorf("start = %s, want %s", g, w)
+	}
+	if g, w := object.Params["type"], "image/png"; g != w {
+		t.Errorf("type = %s, want %s", g, w)
+	}
+
+	var b bytes.Buffer
+	if _, err := object.WriteTo(&b); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	copied := readTestObject(t, b.String(), object.Params, false)
+	if !copied.Lookup("logo@x.y").Root {
+		t.Errorf("logo@x.y isn't root")
+	}
+}
+
+func TestObjectValidate(t *testing.T) {
+	object := readTestObject(t, testHTMLBody, testHTMLParams, false)
+	if err := object.Remove("logo@x"); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if err := object.Validate(); !errors.Is(err, ErrDanglingRef) {
+		t.Errorf("Validate = %v, want %v", err, ErrDanglingRef)
+	}
+
+	object = readTestObject(t, testHTMLBody, testHTMLParams, false)
+	object.Values[2].Header.Set("Content-ID", "<logo@x>")
+	if err := object.Validate(); !errors.Is(err, ErrDupContentId) {
+		t.Errorf("Validate = %v, want %v", err, ErrDupContentId)
+	}
+
+	object = readTestObject(t, testHTMLBody, testHTMLParams, false)
+	object.Values[1].Root = true
+	if err := object.Validate(); err != ErrDupRoot {
+		t.Errorf("Validate = %v, want %v", err, ErrDupRoot)
+	}
+}
+
+func TestReplaceCid(t *testing.T) {
+	tests := []struct {
+		in, old, new, w string
+	}{
+		{`src="cid:a@b"`, "a@b", "c@d", `src="cid:c@d"`},
+		{`src="CID:a@b"`, "a@b", "c@d", `src="CID:c@d"`},
+		{`url(cid:a%40b)`, "a@b", "c@d", `url(cid:c@d)`},
+		{`src="cid:a@bc"`, "a@b", "c@d", `src="cid:a@bc"`},
+		{`cid:a@b cid:a@b`, "a@b", "c@d", `cid:c@d cid:c@d`},
+	}
+	for i, tt := range tests {
+		if g := string(replaceCid([]byte(tt.in), tt.old, tt.new)); g != tt.w {
+			t.Errorf("%d. replaceCid(%s) = %s, want %s", i, tt.in, g, tt.w)
+		}
+	}
+}
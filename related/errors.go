@@ -0,0 +1,112 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package related
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// A PartError records an error of reading or writing a part, together
+// with the part's position. It wraps the cause, so that errors.Is matches
+// sentinels like ErrDupRoot.
+type PartError struct {
+	// Part is the index of the part on the wire.
+	Part int
+
+	// ContentId is the part's Content-ID without angle brackets, if known.
+	ContentId string
+
+	// Offset is the byte offset of the part's delimiter line in the
+	// stream, or -1 if unknown.
+	Offset int64
+
+	Err error
+}
+
+func (e *PartError) Error() string {
+	s := fmt.Sprintf("part %d", e.Part)
+	if e.ContentId != "" {
+		s += " <" + e.ContentId + ">"
+	}
+	if e.Offset >= 0 {
+		s += fmt.Sprintf(" at offset %d", e.Offset)
+	}
+	return s + ": " + e.Err.Error()
+}
+
+// Unwrap returns the cause.
+func (e *PartError) Unwrap() error {
+	return e.Err
+}
+
+// offsetReader records the offsets of the delimiter lines passing
+// through it, so that parts can be located despite multipart.Reader
+// buffering ahead.
+type offsetReader struct {
+	r     io.Reader
+	delim []byte
+
+	pos     int64  // offset of the next byte read
+	line    []byte // beginning of the current line, up to len(delim)+3
+	lineOff int64
+	offsets []int64
+
+	// closed reports whether the close delimiter was read
+	closed bool
+}
+
+func newOffsetReader(r io.Reader, boundary string) *offsetReader {
+	return &offsetReader{r: r, delim: []byte("--" + boundary)}
+}
+
+func (o *offsetReader) Read(p []byte) (int, error) {
+	n, err := o.r.Read(p)
+	for _, c := range p[:n] {
+		if len(o.line) < len(o.delim)+3 && c != '\n' {
+			o.line = append(o.line, c)
+		}
+		o.pos++
+		if c == '\n' {
+			o.endLine(true)
+			o.line = o.line[:0]
+			o.lineOff = o.pos
+		}
+	}
+	if err == io.EOF {
+		o.endLine(false)
+	}
+	return n, err
+}
+
+// endLine records the current line if it's a delimiter line.
+func (o *offsetReader) endLine(newline bool) {
+	if !bytes.HasPrefix(o.line, o.delim) {
+		return
+	}
+	rest := o.line[len(o.delim):]
+	isClose := bytes.HasPrefix(rest, []byte("--"))
+	if isClose {
+		rest = rest[2:]
+	}
+	if len(bytes.Trim(rest, " \t\r")) > 0 {
+		return
+	}
+	if n := len(o.offsets); n > 0 && o.offsets[n-1] == o.lineOff {
+		return
+	}
+	o.offsets = append(o.offsets, o.lineOff)
+	o.closed = o.closed || isClose
+}
+
+// offset returns the offset of the i-th delimiter line, or -1.
+func (o *offsetReader) offset(i int) int64 {
+	if o == nil || i < 0 || i >= len(o.offsets) {
+		return -1
+	}
+	return o.offsets[i]
+}
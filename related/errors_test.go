@@ -0,0 +1,113 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package related
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+var testCorruptBody = "preamble\r\n" +
+	"--example-1\r\n" +
+	"Content-ID: <a@b.c>\r\n" +
+	"Content-Type: a/b\r\n" +
+	"\r\n" +
+	"Life?\r\n" +
+	"--example-1\r\n" +
+	"Content-ID: <b@c.d>\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"RG9uJ3Qg!!!!\r\n" +
+	"--example-1--\r\n"
+
+func TestPartErrorRead(t *testing.T) {
+	_, err := NewReader(strings.NewReader(testCorruptBody), testParams).ReadObject()
+
+	var perr *PartError
+	if !errors.As(err, &perr) {
+		t.Fatalf("ReadObject = %v, want *PartError", err)
+	}
+	if perr.Part != 1 || perr.ContentId != "b@c.d" {
+		t.Errorf("PartError = part %d <%s>, want part 1 <b@c.d>", perr.Part, perr.ContentId)
+	}
+	if g, w := perr.Offset, int64(strings.Index(testCorruptBody, "--example-1\r\nContent-ID: <b@c.d>")); g != w {
+		t.Errorf("Offset = %d, want %d", g, w)
+	}
+	var cerr base64.CorruptInputError
+	if !errors.As(err, &cerr) {
+		t.Errorf("ReadObject = %v, want base64.CorruptInputError", err)
+	}
+	if g, w := perr.Error(), fmt.Sprintf("part 1 <b@c.d> at offset %d: %v", perr.Offset, cerr); g != w {
+		t.Errorf("Error = %q, want %q", g, w)
+	}
+}
+
+func TestPartErrorDupRoot(t *testing.T) {
+	reader := NewReader(strings.NewReader(testDupBody), testParams)
+	var err error
+	for err == nil {
+		_, err = reader.NextPart()
+	}
+	var perr *PartError
+	if !errors.As(err, &perr) || !errors.Is(err, ErrDupRoot) {
+		t.Fatalf("NextPart = %v, want *PartError wrapping %v", err, ErrDupRoot)
+	}
+	if perr.Part != 1 || perr.ContentId != "a@b.c" || perr.Offset < 0 {
+		t.Errorf("PartError = %+v", perr)
+	}
+}
+
+func TestPartErrorWrite(t *testing.T) {
+	var b bytes.Buffer
+	w := NewWriter(&b)
+	if _, err := w.CreatePart("", nil); err != nil {
+		t.Fatalf("CreatePart: %v", err)
+	}
+	offset := int64(b.Len())
+	_, err := w.CreatePart("no content-id", nil)
+
+	var perr *PartError
+	if !errors.As(err, &perr) {
+		t.Fatalf("CreatePart = %v, want *PartError", err)
+	}
+	if perr.Part != 1 || perr.Offset != offset || perr.ContentId != "no content-id" {
+		t.Errorf("PartError = %+v", perr)
+	}
+
+	if err := w.SetType("c/d"); err != nil {
+		t.Fatalf("SetType: %v", err)
+	}
+	err = w.Close()
+	if !errors.As(err, &perr) || !errors.Is(err, ErrTypeMatch) {
+		t.Fatalf("Close = %v, want *PartError wrapping %v", err, ErrTypeMatch)
+	}
+	if perr.Part != 0 || perr.Offset != 0 {
+		t.Errorf("PartError = %+v, want part 0 at offset 0", perr)
+	}
+}
+
+func TestOffsetReader(t *testing.T) {
+	body := "pre\n--b\n\nx\n--bb\n--b \r\n\r\ny\r\n--b--"
+	o := newOffsetReader(iotest.OneByteReader(strings.NewReader(body)), "b")
+	if _, err := io.Copy(ioutil.Discard, o); err != nil {
+		t.Fatalf("Copy: %v", err)
+	}
+	want := []int64{4, 16, 27}
+	if !reflect.DeepEqual(o.offsets, want) {
+		t.Errorf("offsets = %v, want %v", o.offsets, want)
+	}
+	if g := o.offset(3); g != -1 {
+		t.Errorf("offset(3) = %d, want -1", g)
+	}
+}
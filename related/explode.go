@@ -0,0 +1,130 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package related
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime"
+	"net/textproto"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var errDataURI = errors.New("malformed data URI")
+
+// dataURIPattern matches data: URIs as found in attribute values, CSS
+// url() and JSON strings.
+var dataURIPattern = regexp.MustCompile(`(?i)\bdata:([^,"'\s()<>\\]*),([^"'\s()<>\\]*)`)
+
+// Explode is the reverse of Object.Inline. It writes document, of the
+// given media type, as the root and every data: URI (RFC 2397) in it
+// decoding to at least minSize bytes as a part of its own. The data: URIs
+// are replaced by "cid:" URLs; malformed ones are left untouched.
+// Content-IDs are derived from the part's content, so identical data:
+// URIs share a part.
+//
+// Explode doesn't close the Writer.
+func (w *Writer) Explode(mediaType string, document []byte, minSize int) error {
+	type dataPart struct {
+		contentId string
+		mediaType string
+		content   []byte
+	}
+	var parts []*dataPart
+	seen := make(map[string]bool)
+
+	root := dataURIPattern.ReplaceAllFunc(document, func(m []byte) []byte {
+		var p dataPart
+		var err error
+		p.mediaType, p.content, err = parseDataURI(string(m))
+		if err != nil || len(p.content) < minSize {
+			return m
+		}
+		p.contentId = hashContentId(p.content, "data")
+		if !seen[p.contentId] {
+			seen[p.contentId] = true
+			parts = append(parts, &p)
+		}
+		return []byte("cid:" + p.contentId)
+	})
+
+	pw, err := w.CreateRoot("", mediaType, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := pw.Write(root); err != nil {
+		return err
+	}
+	for _, p := range parts {
+		header := make(textproto.MIMEHeader)
+		header.Set("Content-Type", p.mediaType)
+		pw, err := w.CreatePart(p.contentId, header)
+		if err != nil {
+			return err
+		}
+		if _, err := pw.Write(p.content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hashContentId derives a Content-ID in domain from b.
+func hashContentId(b []byte, domain string) string {
+	sum := sha256.Sum256(b)
+	return fmt.Sprintf("%x@%s", sum[:16], domain)
+}
+
+// parseDataURI returns the media type and decoded content of a data: URI.
+func parseDataURI(uri string) (string, []byte, error) {
+	comma := strings.IndexByte(uri, ',')
+	if len(uri) < 5 || !strings.EqualFold(uri[:5], "data:") || comma < 0 {
+		return "", nil, errDataURI
+	}
+	meta, data := uri[5:comma], uri[comma+1:]
+
+	isBase64 := false
+	if i := strings.LastIndexByte(meta, ';'); i >= 0 && strings.EqualFold(meta[i+1:], "base64") {
+		isBase64 = true
+		meta = meta[:i]
+	}
+	mediaType := "text/plain;charset=US-ASCII"
+	if meta != "" {
+		if strings.HasPrefix(meta, ";") {
+			meta = "text/plain" + meta
+		}
+		t, params, err := mime.ParseMediaType(strings.Replace(meta, ";", "; ", -1))
+		if err != nil {
+			return "", nil, fmt.Errorf("%w: %v", errDataURI, err)
+		}
+		mediaType = mime.FormatMediaType(t, params)
+	}
+
+	if !isBase64 {
+		content, err := url.PathUnescape(data)
+		if err != nil {
+			return "", nil, fmt.Errorf("%w: %v", errDataURI, err)
+		}
+		return mediaType, []byte(content), nil
+	}
+	data, err := url.PathUnescape(data)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %v", errDataURI, err)
+	}
+	data = strings.TrimRight(data, "=")
+	content, err := base64.RawStdEncoding.DecodeString(data)
+	if err != nil {
+		content, err = base64.RawURLEncoding.DecodeString(data)
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("%w: %v", errDataURI, err)
+	}
+	return mediaType, content, nil
+}
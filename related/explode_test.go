@@ -0,0 +1,85 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package related
+
+import (
+	"bytes"
+	"mime"
+	"testing"
+)
+
+func TestWriterExplode(t *testing.T) {
+	doc := `<img src="data:image/png;base64,cG5nIGRhdGE="><img src='data:,a'>` +
+		`<div style="background: url(data:image/png;base64,cG5nIGRhdGE=)">` +
+		`<img src="data:image/png;base64,!!!">metadata:a,b`
+
+	var b bytes.Buffer
+	w := NewWriter(&b)
+	if err := w.Explode("text/html", []byte(doc), 2); err != nil {
+		t.Fatalf("Explode: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	_, params, err := mime.ParseMediaType(w.FormDataContentType())
+	if err != nil {
+		t.Fatalf("ParseMediaType: %v", err)
+	}
+	object := readTestObject(t, b.String(), params, false)
+	if len(object.Values) != 2 {
+		t.Fatalf("parts = %d, want 2", len(object.Values))
+	}
+	part := object.Values[1]
+	cid := part.ContentId()
+	want := `<img src="cid:` + cid + `"><img src='data:,a'>` +
+		`<div style="background: url(cid:` + cid + `)">` +
+		`<img src="data:image/png;base64,!!!">metadata:a,b`
+	if g := string(object.Values[0].Bytes()); g != want {
+		t.Errorf("root = %s, want %s", g, want)
+	}
+	if g, w := string(part.Bytes()), "png data"; g != w {
+		t.Errorf("part = %q, want %q", g, w)
+	}
+	if g, w := part.Header.Get("Content-Type"), "image/png"; g != w {
+		t.Errorf("Content-Type = %s, want %s", g, w)
+	}
+
+	inlined, err := object.Inline(0)
+	if err != nil {
+		t.Fatalf("Inline: %v", err)
+	}
+	if bytes.Contains(inlined, []byte("cid:")) {
+		t.Errorf("Inline left cid: URLs: %s", inlined)
+	}
+}
+
+func TestParseDataURI(t *testing.T) {
+	tests := []struct {
+		uri, mediaType, content string
+		ok                      bool
+	}{
+		{"data:,A%20brief%20note", "text/plain;charset=US-ASCII", "A brief note", true},
+		{"data:text/plain;charset=iso-8859-7,%be%d3", "text/plain; charset=iso-8859-7", "\xbe\xd3", true},
+		{"data:image/gif;base64,R0lG", "image/gif", "GIF", true},
+		{"data:;base64,R0lGOA==", "text/plain;charset=US-ASCII", "GIF8", true},
+		{"data:image/gif;base64,R0l-_w", "image/gif", "GI~\xff", true},
+		{"data:image/gif;base64,!", "", "", false},
+		{"data:a;b,c", "", "", false},
+		{"http:,", "", "", false},
+	}
+	for i, tt := range tests {
+		mediaType, content, err := parseDataURI(tt.uri)
+		if (err == nil) != tt.ok {
+			t.Errorf("%d. parseDataURI(%s) = %v, want ok %t", i, tt.uri, err, tt.ok)
+			continue
+		}
+		if mediaType != tt.mediaType || string(content) != tt.content {
+			t.Errorf("%d. parseDataURI(%s) = %s, %q; want %s, %q",
+				i, tt.uri, mediaType, content, tt.mediaType, tt.content)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package related
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"html"
+	"io"
+	"mime"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// An Extractor finds the URLs a part's content refers to, such as
+// "cid:" URLs or the Content-Location of other parts.
+type Extractor interface {
+	References(content []byte) ([]string, error)
+}
+
+// The ExtractorFunc type is an adapter to allow the use of ordinary
+// functions as Extractor.
+type ExtractorFunc func(content []byte) ([]string, error)
+
+// References calls f(content).
+func (f ExtractorFunc) References(content []byte) ([]string, error) {
+	return f(content)
+}
+
+var (
+	extractorsMu sync.RWMutex
+	extractors   = map[string]Extractor{
+		"text/html":             ExtractorFunc(htmlReferences),
+		"application/xhtml+xml": ExtractorFunc(htmlReferences),
+		"text/css":              ExtractorFunc(cssReferences),
+		"application/xml":       ExtractorFunc(xmlReferences),
+		"text/xml":              ExtractorFunc(xmlReferences),
+		"application/json":      ExtractorFunc(jsonReferences),
+	}
+)
+
+// RegisterExtractor makes e available for the given media type, replacing
+// any extractor registered before. A media type of "major/*" registers e
+// for all subtypes without an extractor of their own.
+func RegisterExtractor(mediaType string, e Extractor) {
+	extractorsMu.Lock()
+	defer extractorsMu.Unlock()
+	extractors[strings.ToLower(mediaType)] = e
+}
+
+// lookupExtractor returns the extractor of mediaType. Structured syntax
+// suffixes, e.g. "+xml", fall back to the extractor of
+// application/<suffix>.
+func lookupExtractor(mediaType string) (Extractor, bool) {
+	t, _, err := mime.ParseMediaType(mediaType)
+	if err != nil {
+		return nil, false
+	}
+	extractorsMu.RLock()
+	defer extractorsMu.RUnlock()
+
+	if e, ok := extractors[t]; ok {
+		return e, true
+	}
+	if i := strings.LastIndex(t, "+"); i >= 0 {
+		if e, ok := extractors["application/"+t[i+1:]]; ok {
+			return e, true
+		}
+	}
+	if i := strings.Index(t, "/"); i >= 0 {
+		if e, ok := extractors[t[:i]+"/*"]; ok {
+			return e, true
+		}
+	}
+	return nil, false
+}
+
+var (
+	htmlAttrPattern  = regexp.MustCompile(`(?is)[\s"'](src|href|background|poster|data|lowsrc|longdesc|srcset)\s*=\s*(?:"([^"]*)"|'([^']*)'|([^\s"'>]+))`)
+	cssURLPattern    = regexp.MustCompile(`(?is)url\(\s*(?:"([^"]*)"|'([^']*)'|([^)"'\s]*))\s*\)`)
+	cssImportPattern = regexp.MustCompile(`(?is)@import\s+(?:"([^"]*)"|'([^']*)')`)
+)
+
+// htmlReferences extracts URLs of src, href, srcset and similar
+// attributes, and of url() in style attributes and elements.
+func htmlReferences(content []byte) ([]string, error) {
+	var refs []string
+	for _, m := range htmlAttrPattern.FindAllSubmatch(content, -1) {
+		v := html.UnescapeString(firstGroup(m[2:]))
+		if !strings.EqualFold(string(m[1]), "srcset") {
+			refs = append(refs, strings.TrimSpace(v))
+			continue
+		}
+		for _, candidate := range strings.Split(v, ",") {
+			if fields := strings.Fields(candidate); len(fields) > 0 {
+				refs = append(refs, fields[0])
+			}
+		}
+	}
+	css, _ := cssReferences([]byte(html.UnescapeString(string(content))))
+	return append(refs, css...), nil
+}
+
+// cssReferences extracts URLs of url() and @import.
+func cssReferences(content []byte) ([]string, error) {
+	var refs []string
+	for _, p := range []*regexp.Regexp{cssURLPattern, cssImportPattern} {
+		for _, m := range p.FindAllSubmatch(content, -1) {
+			refs = append(refs, strings.TrimSpace(firstGroup(m[1:])))
+		}
+	}
+	return refs, nil
+}
+
+// xmlReferences extracts URLs of href and src attributes, in any
+// namespace, and of attributes holding a "cid:" URL. This covers
+// xlink:href as well as the href of XOP's xop:Include, see
+// https://www.w3.org/TR/xop10/
+func xmlReferences(content []byte) ([]string, error) {
+	var refs []string
+	d := xml.NewDecoder(bytes.NewReader(content))
+	d.Strict = false
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return refs, nil
+		}
+		if err != nil {
+			return refs, err
+		}
+		el, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		for _, attr := range el.Attr {
+			switch {
+			case attr.Name.Local == "href", attr.Name.Local == "src":
+				refs = append(refs, strings.TrimSpace(attr.Value))
+			case attr.Name.Local == "style":
+				css, _ := cssReferences([]byte(attr.Value))
+				refs = append(refs, css...)
+			case hasCidScheme(attr.Value):
+				refs = append(refs, strings.TrimSpace(attr.Value))
+			}
+		}
+	}
+}
+
+// jsonReferences extracts string values starting with "cid:".
+func jsonReferences(content []byte) ([]string, error) {
+	var v interface{}
+	if err := json.Unmarshal(content, &v); err != nil {
+		return nil, err
+	}
+	var refs []string
+	var walk func(v interface{})
+	walk = func(v interface{}) {
+		switch t := v.(type) {
+		case string:
+			if hasCidScheme(t) {
+				refs = append(refs, t)
+			}
+		case []interface{}:
+			for _, e := range t {
+				walk(e)
+			}
+		case map[string]interface{}:
+			keys := make([]string, 0, len(t))
+			for k := range t {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				walk(t[k])
+			}
+		}
+	}
+	walk(v)
+	return refs, nil
+}
+
+func hasCidScheme(s string) bool {
+	return len(s) > 4 && strings.EqualFold(s[:4], "cid:")
+}
+
+// firstGroup returns the first non-empty submatch.
+func firstGroup(groups [][]byte) string {
+	for _, g := range groups {
+		if len(g) > 0 {
+			return string(g)
+		}
+	}
+	return ""
+}
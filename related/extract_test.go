@@ -0,0 +1,91 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package related
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestExtractors(t *testing.T) {
+	tests := []struct {
+		mediaType string
+		content   string
+		w         []string
+	}{
+		{
+			"text/html; charset=utf-8",
+			`<img src="cid:a@b" alt=x><a HREF='page.html'>` +
+				`<img srcset="small.png 1x, big.png 2x" src=x.png>` +
+				`<div style="background: url(&quot;bg.png&quot;)"></div>` +
+				`<style>@import "s.css"; p { background: url( 'p.png' ) }</style>`,
+			[]string{"cid:a@b", "page.html", "small.png", "big.png", "x.png",
+				"bg.png", "p.png", "s.css"},
+		},
+		{
+			"text/css",
+			`@import 'a.css'; body { background: url(cid:bg@x) }`,
+			[]string{"cid:bg@x", "a.css"},
+		},
+		{
+			"image/svg+xml",
+			`<svg xmlns:xlink="http://www.w3.org/1999/xlink"><image xlink:href="cid:i@x"/>` +
+				`<rect fill="url(#g)" style="fill: url(p.png)"/></svg>`,
+			[]string{"cid:i@x", "p.png"},
+		},
+		{
+			"application/soap+xml",
+			`<Envelope><data><xop:Include xmlns:xop="http://www.w3.org/2004/08/xop/include" ` +
+				`href="cid:bin@x"/></data><ref id="cid:other@x"/></Envelope>`,
+			[]string{"cid:bin@x", "cid:other@x"},
+		},
+		{
+			"application/json",
+			`{"b": ["cid:b@x", 1, "text"], "a": {"c": "CID:a@x"}}`,
+			[]string{"CID:a@x", "cid:b@x"},
+		},
+	}
+	for i, tt := range tests {
+		e, ok := lookupExtractor(tt.mediaType)
+		if !ok {
+			t.Errorf("%d. no extractor for %s", i, tt.mediaType)
+			continue
+		}
+		got, err := e.References([]byte(tt.content))
+		if err != nil {
+			t.Errorf("%d. References: %v", i, err)
+		}
+		if !reflect.DeepEqual(got, tt.w) {
+			t.Errorf("%d. References = %q, want %q", i, got, tt.w)
+		}
+	}
+
+	if _, ok := lookupExtractor("image/png"); ok {
+		t.Errorf("unexpected extractor for image/png")
+	}
+	if _, err := jsonReferences([]byte("{")); err == nil {
+		t.Errorf("jsonReferences: expected error")
+	}
+}
+
+func TestRegisterExtractor(t *testing.T) {
+	RegisterExtractor("text/*", ExtractorFunc(func(b []byte) ([]string, error) {
+		return []string{string(b)}, nil
+	}))
+	defer func() {
+		extractorsMu.Lock()
+		delete(extractors, "text/*")
+		extractorsMu.Unlock()
+	}()
+
+	e, ok := lookupExtractor("text/plain")
+	if !ok {
+		t.Fatal("no extractor for text/plain")
+	}
+	if got, _ := e.References([]byte("cid:a@b")); !reflect.DeepEqual(got, []string{"cid:a@b"}) {
+		t.Errorf("References = %q", got)
+	}
+}
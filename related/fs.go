@@ -0,0 +1,195 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package related
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Object implements fs.FS and fs.ReadFileFS, so that a parsed compound
+// object can be handed to html/template, http.FileServerFS and the like.
+//
+// A part is found at the path of its Content-Location, the host of an
+// absolute URL being the first path element, e.g. "example.com/doc/a.png",
+// and one ending in a slash naming its index.html. Parts with a
+// Content-ID are found at "cid/<id>" as well, the id escaped as URL path
+// segment. FileInfo.Sys returns the part's Content-Type.
+var (
+	_ fs.FS         = (*Object)(nil)
+	_ fs.ReadFileFS = (*Object)(nil)
+)
+
+// Open opens the named part or directory, see fs.FS.
+func (o *Object) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	files := o.files()
+	if oh, ok := files[name]; ok {
+		return &objectFile{
+			Reader: bytes.NewReader(oh.content),
+			info:   &objectFileInfo{name: path.Base(name), oh: oh},
+		}, nil
+	}
+
+	entries := make(map[string]fs.DirEntry)
+	for p, oh := range files {
+		rel := p
+		if name != "." {
+			if !strings.HasPrefix(p, name+"/") {
+				continue
+			}
+			rel = p[len(name)+1:]
+		}
+		if i := strings.IndexByte(rel, '/'); i >= 0 {
+			entries[rel[:i]] = fs.FileInfoToDirEntry(&objectFileInfo{name: rel[:i]})
+		} else {
+			entries[rel] = fs.FileInfoToDirEntry(&objectFileInfo{name: rel, oh: oh})
+		}
+	}
+	if len(entries) == 0 && name != "." {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+	}
+	d := &objectDir{info: &objectFileInfo{name: path.Base(name)}}
+	for _, e := range entries {
+		d.entries = append(d.entries, e)
+	}
+	sort.Slice(d.entries, func(i, j int) bool {
+		return d.entries[i].Name() < d.entries[j].Name()
+	})
+	return d, nil
+}
+
+// ReadFile returns a copy of the named part's content, see fs.ReadFileFS.
+func (o *Object) ReadFile(name string) ([]byte, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: fs.ErrInvalid}
+	}
+	oh, ok := o.files()[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "readfile", Path: name, Err: fs.ErrNotExist}
+	}
+	return append([]byte(nil), oh.content...), nil
+}
+
+// files maps paths to parts. The first part claiming a path wins.
+func (o *Object) files() map[string]*ObjectHeader {
+	files := make(map[string]*ObjectHeader)
+	add := func(name string, oh *ObjectHeader) {
+		if _, ok := files[name]; !ok && fs.ValidPath(name) && name != "." {
+			files[name] = oh
+		}
+	}
+	for _, oh := range o.Values {
+		if name := locationPath(oh.location()); name != "" {
+			add(name, oh)
+		}
+		if cid := oh.ContentId(); cid != "" {
+			add("cid/"+url.PathEscape(cid), oh)
+		}
+	}
+	return files
+}
+
+// locationPath maps a Content-Location to a path, dropping scheme, query
+// and fragment.
+func locationPath(location string) string {
+	u, err := url.Parse(location)
+	if err != nil || u.Opaque != "" {
+		return ""
+	}
+	p := u.Path
+	if u.Host != "" && p == "" || strings.HasSuffix(p, "/") {
+		p += "/index.html"
+	}
+	p = strings.TrimPrefix(path.Clean("/"+p), "/")
+	if u.Host != "" {
+		p = u.Host + "/" + p
+	}
+	if p == "" || p == "." {
+		return ""
+	}
+	return p
+}
+
+// objectFile is an open part.
+type objectFile struct {
+	*bytes.Reader
+	info *objectFileInfo
+}
+
+func (f *objectFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *objectFile) Close() error               { return nil }
+
+// objectDir is an open directory.
+type objectDir struct {
+	info    *objectFileInfo
+	entries []fs.DirEntry
+	off     int
+}
+
+func (d *objectDir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *objectDir) Close() error               { return nil }
+
+func (d *objectDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: fs.ErrInvalid}
+}
+
+// ReadDir reads the directory, see fs.ReadDirFile.
+func (d *objectDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	rest := d.entries[d.off:]
+	if n <= 0 {
+		d.off = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(rest) {
+		n = len(rest)
+	}
+	d.off += n
+	return rest[:n], nil
+}
+
+// objectFileInfo describes a part, or a directory if oh is nil.
+type objectFileInfo struct {
+	name string
+	oh   *ObjectHeader
+}
+
+func (fi *objectFileInfo) Name() string       { return fi.name }
+func (fi *objectFileInfo) ModTime() time.Time { return time.Time{} }
+func (fi *objectFileInfo) IsDir() bool        { return fi.oh == nil }
+
+func (fi *objectFileInfo) Size() int64 {
+	if fi.oh == nil {
+		return 0
+	}
+	return int64(len(fi.oh.content))
+}
+
+func (fi *objectFileInfo) Mode() fs.FileMode {
+	if fi.oh == nil {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+// Sys returns the part's Content-Type.
+func (fi *objectFileInfo) Sys() interface{} {
+	if fi.oh == nil {
+		return nil
+	}
+	return fi.oh.Header.Get("Content-Type")
+}
@@ -0,0 +1,96 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package related
+
+import (
+	"errors"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+func TestObjectFS(t *testing.T) {
+	params := map[string]string{"boundary": "example-3", "type": "text/html"}
+	object := readTestObject(t, testGraphBody, params, false)
+
+	if err := fstest.TestFS(object,
+		"example.com/doc/index.html",
+		"example.com/doc/style.css",
+		"cid/page@x",
+		"cid/logo@x",
+		"cid/unused@x",
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := fs.ReadFile(object, "example.com/doc/style.css")
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	if g, w := string(b), "body { background: url(cid:page@x) }"; g != w {
+		t.Errorf("ReadFile = %q, want %q", g, w)
+	}
+
+	fi, err := fs.Stat(object, "cid/logo@x")
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	if g, w := fi.Sys(), "image/png"; g != w {
+		t.Errorf("Sys = %v, want %s", g, w)
+	}
+	if fi.Size() != 3 {
+		t.Errorf("Size = %d, want 3", fi.Size())
+	}
+
+	if _, err := object.Open("missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Open = %v, want %v", err, fs.ErrNotExist)
+	}
+	if _, err := object.Open("../x"); !errors.Is(err, fs.ErrInvalid) {
+		t.Errorf("Open = %v, want %v", err, fs.ErrInvalid)
+	}
+}
+
+func TestObjectFileServer(t *testing.T) {
+	params := map[string]string{"boundary": "example-3", "type": "text/html"}
+	object := readTestObject(t, testGraphBody, params, false)
+
+	srv := httptest.NewServer(http.FileServer(http.FS(object)))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/example.com/doc/style.css")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+	if g, w := resp.Header.Get("Content-Type"), "text/css; charset=utf-8"; g != w {
+		t.Errorf("Content-Type = %s, want %s", g, w)
+	}
+}
+
+func TestLocationPath(t *testing.T) {
+	tests := []struct {
+		loc, w string
+	}{
+		{"http://example.com/a/b.png?x=1#y", "example.com/a/b.png"},
+		{"http://example.com/", "example.com/index.html"},
+		{"http://example.com", "example.com/index.html"},
+		{"images/../../a.png", "a.png"},
+		{"/a//b.png", "a/b.png"},
+		{"http://example.com/../../etc/passwd", "example.com/etc/passwd"},
+		{"mailto:a@b.c", ""},
+		{"", ""},
+	}
+	for i, tt := range tests {
+		if g := locationPath(tt.loc); g != tt.w {
+			t.Errorf("%d. locationPath(%s) = %s, want %s", i, tt.loc, g, tt.w)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package related
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ErrOrphan is reported for parts not used by the compound object.
+var ErrOrphan = errors.New("part not reachable from root")
+
+// A Reference is a URL found in the content of a part.
+type Reference struct {
+	From *ObjectHeader
+	URL  string
+
+	// To is the part the URL resolves to, either by Content-ID for "cid:"
+	// URLs or by Content-Location, see RFC 2557. It's nil for external
+	// and dangling references.
+	To *ObjectHeader
+}
+
+// Dangling reports whether r is a "cid:" URL without matching part.
+func (r *Reference) Dangling() bool {
+	return r.To == nil && hasCidScheme(r.URL)
+}
+
+// An ExtractError records an Extractor failing on a part's content, e.g.
+// on malformed JSON.
+type ExtractError struct {
+	Part *ObjectHeader
+	Err  error
+}
+
+func (e *ExtractError) Error() string {
+	return fmt.Sprintf("part %s: %v", describePart(e.Part), e.Err)
+}
+
+func (e *ExtractError) Unwrap() error {
+	return e.Err
+}
+
+// A Graph describes which parts of a compound object refer to each other.
+type Graph struct {
+	Root       *ObjectHeader
+	Parts      []*ObjectHeader
+	References []*Reference
+
+	// Errors lists the parts whose references couldn't be extracted.
+	// They are treated as referring to nothing.
+	Errors []*ExtractError
+}
+
+// A GraphReport lists the findings of Graph.Validate.
+type GraphReport struct {
+	// Dangling lists "cid:" references without matching part.
+	Dangling []*Reference
+
+	// Orphans lists the parts not reachable from the root.
+	Orphans []*ObjectHeader
+
+	// Cycles lists reference cycles. They are legal, but worth a warning.
+	Cycles [][]*ObjectHeader
+
+	// Unextracted lists the extraction failures of the Graph. Parts only
+	// referenced by such a part are listed as Orphans.
+	Unextracted []*ExtractError
+}
+
+// Err returns an error describing dangling references and orphans, or nil.
+// Cycles and extraction failures are not considered an error.
+func (r *GraphReport) Err() error {
+	switch {
+	case len(r.Dangling) > 0:
+		return fmt.Errorf("%w: %s", ErrDanglingRef, r.Dangling[0].URL)
+	case len(r.Orphans) > 0:
+		return fmt.Errorf("%w: %s", ErrOrphan, describePart(r.Orphans[0]))
+	}
+	return nil
+}
+
+// Graph extracts the references of all parts with a registered Extractor
+// and resolves them. Extractor failures are recorded in Errors, skipping
+// the part.
+func (o *Object) Graph() *Graph {
+	g := &Graph{Parts: o.Values}
+	byId := make(map[string]*ObjectHeader)
+	byLocation := make(map[string]*ObjectHeader)
+	for _, oh := range o.Values {
+		if oh.Root && g.Root == nil {
+			g.Root = oh
+		}
+		if cid := oh.ContentId(); cid != "" {
+			byId[cid] = oh
+		}
+		if loc := oh.location(); loc != "" {
+			byLocation[loc] = oh
+		}
+	}
+
+	for _, oh := range o.Values {
+		e, ok := lookupExtractor(oh.Header.Get("Content-Type"))
+		if !ok {
+			continue
+		}
+		urls, err := e.References(oh.content)
+		if err != nil {
+			g.Errors = append(g.Errors, &ExtractError{Part: oh, Err: err})
+			continue
+		}
+		for _, u := range urls {
+			if u == "" || strings.HasPrefix(u, "#") {
+				continue
+			}
+			ref := &Reference{From: oh, URL: u}
+			if hasCidScheme(u) {
+				ref.To = byId[unescapeCid(u[4:])]
+			} else if loc := resolveLocation(oh.location(), u); loc != "" {
+				ref.To = byLocation[loc]
+			}
+			g.References = append(g.References, ref)
+		}
+	}
+	return g
+}
+
+// Validate reports dangling references, orphans and cycles.
+func (g *Graph) Validate() *GraphReport {
+	r := &GraphReport{Unextracted: g.Errors}
+	edges := make(map[*ObjectHeader][]*ObjectHeader)
+	for _, ref := range g.References {
+		if ref.Dangling() {
+			r.Dangling = append(r.Dangling, ref)
+		}
+		if ref.To != nil {
+			edges[ref.From] = append(edges[ref.From], ref.To)
+		}
+	}
+
+	reached := make(map[*ObjectHeader]bool)
+	var reach func(oh *ObjectHeader)
+	reach = func(oh *ObjectHeader) {
+		if reached[oh] {
+			return
+		}
+		reached[oh] = true
+		for _, to := range edges[oh] {
+			reach(to)
+		}
+	}
+	if g.Root != nil {
+		reach(g.Root)
+	}
+	for _, oh := range g.Parts {
+		if !reached[oh] {
+			r.Orphans = append(r.Orphans, oh)
+		}
+	}
+
+	// Depth-first search, a back edge closes a cycle
+	const (
+		unvisited = iota
+		active
+		done
+	)
+	state := make(map[*ObjectHeader]int)
+	var path []*ObjectHeader
+	var visit func(oh *ObjectHeader)
+	visit = func(oh *ObjectHeader) {
+		state[oh] = active
+		path = append(path, oh)
+		seen := make(map[*ObjectHeader]bool)
+		for _, to := range edges[oh] {
+			if seen[to] {
+				continue
+			}
+			seen[to] = true
+			switch state[to] {
+			case unvisited:
+				visit(to)
+			case active:
+				for i := len(path) - 1; i >= 0; i-- {
+					if path[i] == to {
+						cycle := append([]*ObjectHeader(nil), path[i:]...)
+						r.Cycles = append(r.Cycles, cycle)
+						break
+					}
+				}
+			}
+		}
+		path = path[:len(path)-1]
+		state[oh] = done
+	}
+	for _, oh := range g.Parts {
+		if state[oh] == unvisited {
+			visit(oh)
+		}
+	}
+	return r
+}
+
+// location returns the absolute or relative Content-Location of a
+// ObjectHeader, resolved against its Content-Base.
+func (oh *ObjectHeader) location() string {
+	loc := strings.TrimSpace(oh.Header.Get("Content-Location"))
+	if loc == "" {
+		return ""
+	}
+	if base := strings.TrimSpace(oh.Header.Get("Content-Base")); base != "" {
+		return resolveLocation(base, loc)
+	}
+	return loc
+}
+
+// resolveLocation resolves ref against base, see RFC 2557 section 5. A
+// relative base yields a relative location.
+func resolveLocation(base, ref string) string {
+	u, err := url.Parse(ref)
+	if err != nil {
+		return ""
+	}
+	if base == "" || u.IsAbs() {
+		return u.String()
+	}
+	b, err := url.Parse(base)
+	if err != nil {
+		return u.String()
+	}
+	loc := b.ResolveReference(u).String()
+	if !b.IsAbs() && b.Host == "" && !strings.HasPrefix(base, "/") {
+		loc = strings.TrimPrefix(loc, "/")
+	}
+	return loc
+}
+
+// describePart names a part by its Content-ID or Content-Location.
+func describePart(oh *ObjectHeader) string {
+	if cid := oh.ContentId(); cid != "" {
+		return "<" + cid + ">"
+	}
+	if loc := oh.location(); loc != "" {
+		return loc
+	}
+	return fmt.Sprintf("#%d", oh.order)
+}
@@ -0,0 +1,105 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package related
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+var testGraphBody = "--example-3\r\n" +
+	"Content-Type: text/html\r\n" +
+	"Content-ID: <page@x>\r\n" +
+	"Content-Location: http://example.com/doc/index.html\r\n" +
+	"\r\n" +
+	`<link href="style.css"><img src="cid:logo@x"><img src="cid:gone@x">` +
+	`<a href="http://example.org/">` + "\r\n" +
+	"--example-3\r\n" +
+	"Content-Type: text/css\r\n" +
+	"Content-Location: http://example.com/doc/style.css\r\n" +
+	"\r\n" +
+	`body { background: url(cid:page@x) }` + "\r\n" +
+	"--example-3\r\n" +
+	"Content-Type: image/png\r\n" +
+	"Content-ID: <logo@x>\r\n" +
+	"\r\n" +
+	"png\r\n" +
+	"--example-3\r\n" +
+	"Content-Type: image/png\r\n" +
+	"Content-ID: <unused@x>\r\n" +
+	"\r\n" +
+	"png\r\n" +
+	"--example-3--\r\n"
+
+func TestObjectGraph(t *testing.T) {
+	object := readTestObject(t, testGraphBody, map[string]string{
+		"boundary": "example-3",
+		"type":     "text/html",
+	}, false)
+
+	g := object.Graph()
+	if g.Root != object.Values[0] {
+		t.Errorf("Root = %v, want first part", g.Root)
+	}
+
+	var got []string
+	for _, ref := range g.References {
+		to := "-"
+		if ref.To != nil {
+			to = describePart(ref.To)
+		}
+		got = append(got, describePart(ref.From)+" "+ref.URL+" "+to)
+	}
+	want := []string{
+		"<page@x> style.css http://example.com/doc/style.css",
+		"<page@x> cid:logo@x <logo@x>",
+		"<page@x> cid:gone@x -",
+		"<page@x> http://example.org/ -",
+		"http://example.com/doc/style.css cid:page@x <page@x>",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("References = %q, want %q", got, want)
+	}
+
+	r := g.Validate()
+	if len(r.Dangling) != 1 || r.Dangling[0].URL != "cid:gone@x" {
+		t.Errorf("Dangling = %v, want cid:gone@x", r.Dangling)
+	}
+	if len(r.Orphans) != 1 || r.Orphans[0].ContentId() != "unused@x" {
+		t.Errorf("Orphans = %v, want <unused@x>", r.Orphans)
+	}
+	if len(r.Cycles) != 1 || len(r.Cycles[0]) != 2 || r.Cycles[0][0] != g.Root {
+		t.Errorf("Cycles = %v, want page and style", r.Cycles)
+	}
+	if err := r.Err(); !errors.Is(err, ErrDanglingRef) {
+		t.Errorf("Err = %v, want %v", err, ErrDanglingRef)
+	}
+	r.Dangling = nil
+	if err := r.Err(); !errors.Is(err, ErrOrphan) {
+		t.Errorf("Err = %v, want %v", err, ErrOrphan)
+	}
+}
+
+func TestResolveLocation(t *testing.T) {
+	tests := []struct {
+		base, ref, w string
+	}{
+		{"http://a/b/c.html", "d.png", "http://a/b/d.png"},
+		{"http://a/b/c.html", "/d.png", "http://a/d.png"},
+		{"", "d.png", "d.png"},
+		{"http://a/b/", "http://x/y", "http://x/y"},
+		{"http://a/", "%zz", ""},
+		{"report/index.html", "img/a.png", "report/img/a.png"},
+		{"index.html", "../a.png", "a.png"},
+		{"/doc/index.html", "a.png", "/doc/a.png"},
+	}
+	for i, tt := range tests {
+		if g := resolveLocation(tt.base, tt.ref); g != tt.w {
+			t.Errorf("%d. resolveLocation(%s, %s) = %s, want %s", i, tt.base, tt.ref, g, tt.w)
+		}
+	}
+}
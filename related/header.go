@@ -0,0 +1,148 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package related
+
+import (
+	"errors"
+	"fmt"
+	"mime"
+	"net/textproto"
+	"strings"
+	"unicode/utf8"
+)
+
+// ErrInvalidHeader is wrapped by a HeaderError.
+var ErrInvalidHeader = errors.New("invalid header field")
+
+// A HeaderError is returned by the Writer for a header field that can't
+// be written safely, e.g. one whose value contains a line break.
+type HeaderError struct {
+	Key    string
+	Reason string
+}
+
+func (e *HeaderError) Error() string {
+	return fmt.Sprintf("invalid header field %q: %s", e.Key, e.Reason)
+}
+
+// Unwrap returns ErrInvalidHeader.
+func (e *HeaderError) Unwrap() error {
+	return ErrInvalidHeader
+}
+
+// foldLength is the recommended line length header fields are folded at, see
+// RFC 5322, section 2.1.1.
+const foldLength = 78
+
+// encodeHeader returns a copy of header with validated names and values.
+// Non-ASCII values are encoded, using RFC 2231 for the parameters of
+// Content-Type and Content-Disposition and RFC 2047 otherwise.
+func encodeHeader(header textproto.MIMEHeader) (textproto.MIMEHeader, error) {
+	h := make(textproto.MIMEHeader, len(header))
+	for k, v := range header {
+		if err := validHeaderKey(k); err != nil {
+			return nil, err
+		}
+		values := make([]string, len(v))
+		for i, s := range v {
+			s, err := encodeHeaderValue(k, s)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = s
+		}
+		h[k] = values
+	}
+	return h, nil
+}
+
+// validHeaderKey checks that k consists of printable ASCII characters
+// other than colon, see RFC 5322, section 2.2.
+func validHeaderKey(k string) error {
+	if k == "" {
+		return &HeaderError{Key: k, Reason: "empty name"}
+	}
+	for i := 0; i < len(k); i++ {
+		if c := k[i]; c < '!' || c > '~' || c == ':' {
+			return &HeaderError{Key: k, Reason: fmt.Sprintf("invalid character %q in name", c)}
+		}
+	}
+	return nil
+}
+
+func encodeHeaderValue(k, v string) (string, error) {
+	ascii := true
+	for i := 0; i < len(v); i++ {
+		switch c := v[i]; {
+		case c == '\r' || c == '\n':
+			return "", &HeaderError{Key: k, Reason: "line break in value"}
+		case c < ' ' && c != '\t' || c == 0x7f:
+			return "", &HeaderError{Key: k, Reason: fmt.Sprintf("control character %q in value", c)}
+		case c >= utf8.RuneSelf:
+			ascii = false
+		}
+	}
+	if ascii {
+		return v, nil
+	}
+	if !utf8.ValidString(v) {
+		return "", &HeaderError{Key: k, Reason: "value is not valid UTF-8"}
+	}
+
+	switch textproto.CanonicalMIMEHeaderKey(k) {
+	case "Content-Type", "Content-Disposition":
+		t, params, err := mime.ParseMediaType(v)
+		if err != nil {
+			return "", &HeaderError{Key: k, Reason: err.Error()}
+		}
+		if s := mime.FormatMediaType(t, params); s != "" {
+			return s, nil
+		}
+		return "", &HeaderError{Key: k, Reason: "non-ASCII value outside parameters"}
+	}
+	return mime.QEncoding.Encode("utf-8", v), nil
+}
+
+// foldHeader returns a copy of header with values folded, see
+// foldHeaderValue.
+func foldHeader(header textproto.MIMEHeader) textproto.MIMEHeader {
+	h := make(textproto.MIMEHeader, len(header))
+	for k, v := range header {
+		values := make([]string, len(v))
+		for i, s := range v {
+			values[i] = foldHeaderValue(k, s)
+		}
+		h[k] = values
+	}
+	return h
+}
+
+// foldHeaderValue breaks the line of the field k before spaces to keep it
+// within foldLength. A first word not fitting after the name, such as an
+// RFC 2047 encoded-word, starts a line of its own. Words longer than a
+// line are left as they are.
+func foldHeaderValue(k, v string) string {
+	n := len(k) + len(": ")
+	if n+len(v) <= foldLength {
+		return v
+	}
+	var b strings.Builder
+	for i, word := range strings.Split(v, " ") {
+		sep := " "
+		if i == 0 {
+			sep = ""
+		}
+		if n+len(sep)+len(word) > foldLength && (i > 0 && n > 1 || i == 0 && len(word) < foldLength) {
+			b.WriteString("\r\n")
+			n = 0
+			sep = " "
+		}
+		b.WriteString(sep)
+		b.WriteString(word)
+		n += len(sep) + len(word)
+	}
+	return b.String()
+}
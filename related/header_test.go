@@ -0,0 +1,133 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package related
+
+import (
+	"bytes"
+	"errors"
+	"mime"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+func TestHeaderInvalid(t *testing.T) {
+	tests := []textproto.MIMEHeader{
+		{"X-Marvin": {"Life?\r\nX-Injected: 1"}},
+		{"X-Marvin": {"Life?\n\r\n--b"}},
+		{"X-Marvin": {"Life\x00"}},
+		{"X-Marvin": {"\xff"}},
+		{"X Marvin": {"Life?"}},
+		{"X:Marvin": {"Life?"}},
+		{"": {"Life?"}},
+		{"Content-Type": {"täxt/plain"}},
+	}
+	for _, header := range tests {
+		w := NewWriter(&bytes.Buffer{})
+		_, err := w.CreatePart("a@b.c", header)
+		var herr *HeaderError
+		var perr *PartError
+		if !errors.As(err, &herr) || !errors.Is(err, ErrInvalidHeader) ||
+			!errors.As(err, &perr) || perr.ContentId != "a@b.c" {
+			t.Errorf("CreatePart(%q) = %v, want *HeaderError in part", header, err)
+		}
+		if _, err := w.CreateRoot("a@b.c", "", header); !errors.As(err, &herr) {
+			t.Errorf("CreateRoot(%q) = %v, want *HeaderError", header, err)
+		}
+		if err := w.SetMessageHeader(header); err == nil && header.Get("Content-Type") == "" {
+			t.Errorf("SetMessageHeader(%q) accepted", header)
+		}
+	}
+}
+
+func TestHeaderInvalidMediaType(t *testing.T) {
+	for _, mediaType := range []string{"text/html\r\n", "text/html\r\n\r\nX-A: 1", "\ntext/html"} {
+		var b bytes.Buffer
+		w := NewWriter(&b)
+		_, err := w.CreateRoot("", mediaType, textproto.MIMEHeader{"X-A": {"1"}})
+		var herr *HeaderError
+		if !errors.As(err, &herr) {
+			t.Errorf("CreateRoot(%q) = %v, want *HeaderError", mediaType, err)
+		}
+		if b.Len() != 0 {
+			t.Errorf("CreateRoot(%q) wrote %q", mediaType, b.String())
+		}
+	}
+}
+
+func TestHeaderEncoding(t *testing.T) {
+	subject := "Das Leben? Reden Sie mir nicht vom Leben! Grüße aus Magrathea, " +
+		"vom Herz aus Gold, von Marvin und von all den anderen Tieren"
+	var b bytes.Buffer
+	w := NewWriter(&b)
+	w.SetMessageHeader(textproto.MIMEHeader{"Subject": {subject}})
+	header := textproto.MIMEHeader{
+		"Content-Type":        {"text/plain"},
+		"Content-Disposition": {`attachment; filename="Grüße.txt"`},
+		"Content-Description": {subject},
+	}
+	part, err := w.CreatePart("a@b.c", header)
+	if err != nil {
+		t.Fatalf("CreatePart: %v", err)
+	}
+	part.Write([]byte("Life?"))
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if header.Get("Content-Id") != "" {
+		t.Errorf("caller's header modified: %q", header)
+	}
+
+	for _, line := range strings.Split(b.String(), "\r\n") {
+		if len(line) > foldLength {
+			t.Errorf("line of %d characters: %q", len(line), line)
+		}
+		for i := 0; i < len(line); i++ {
+			if line[i] >= 0x80 {
+				t.Errorf("non-ASCII line %q", line)
+				break
+			}
+		}
+	}
+
+	r, err := NewMessageReader(&b)
+	if err != nil {
+		t.Fatalf("NewMessageReader: %v", err)
+	}
+	dec := new(mime.WordDecoder)
+	if g, err := dec.DecodeHeader(r.Header().Get("Subject")); err != nil || g != subject {
+		t.Errorf("Subject = %q, %v, want %q", g, err, subject)
+	}
+	p, err := r.NextPart()
+	if err != nil {
+		t.Fatalf("NextPart: %v", err)
+	}
+	if g, err := dec.DecodeHeader(p.Header.Get("Content-Description")); err != nil || g != subject {
+		t.Errorf("Content-Description = %q, %v, want %q", g, err, subject)
+	}
+	_, params, err := mime.ParseMediaType(p.Header.Get("Content-Disposition"))
+	if err != nil || params["filename"] != "Grüße.txt" {
+		t.Errorf("Content-Disposition = %q, %v, want filename Grüße.txt", p.Header.Get("Content-Disposition"), err)
+	}
+}
+
+func TestFoldHeaderValue(t *testing.T) {
+	long := strings.Repeat("x", 80)
+	tests := []struct {
+		key, value, want string
+	}{
+		{"Subject", "Life?", "Life?"},
+		{"Subject", strings.Repeat("Life? ", 14), strings.Repeat("Life? ", 10) + "Life?\r\n Life? Life? Life? "},
+		{"Content-Description", "=?utf-8?q?" + strings.Repeat("x", 63) + "?= b", "\r\n =?utf-8?q?" + strings.Repeat("x", 63) + "?= b"},
+		{"Subject", long, long},
+		{"Subject", "a " + long + " b", "a\r\n " + long + "\r\n b"},
+	}
+	for _, test := range tests {
+		if g := foldHeaderValue(test.key, test.value); g != test.want {
+			t.Errorf("foldHeaderValue(%q, %q) = %q, want %q", test.key, test.value, g, test.want)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package related
+
+import (
+	"bytes"
+	"encoding/base64"
+	"mime"
+	"sort"
+	"strings"
+)
+
+// Inline flattens the compound object into a single self-contained
+// document. Every reference of the root, and recursively of the parts it
+// refers to such as style sheets, resolving to a part is replaced by a
+// data: URI (RFC 2397) carrying the part's content and media type.
+//
+// Parts larger than maxSize bytes, after inlining their own references,
+// are left external; a maxSize of 0 or less means no limit. References
+// closing a cycle are left external as well.
+func (o *Object) Inline(maxSize int) ([]byte, error) {
+	g := o.Graph()
+	if g.Root == nil {
+		return nil, ErrNoRoot
+	}
+
+	refs := make(map[*ObjectHeader][]*Reference)
+	for _, ref := range g.References {
+		if ref.To != nil {
+			refs[ref.From] = append(refs[ref.From], ref)
+		}
+	}
+
+	done := make(map[*ObjectHeader][]byte)
+	active := make(map[*ObjectHeader]bool)
+	var inline func(oh *ObjectHeader) []byte
+	inline = func(oh *ObjectHeader) []byte {
+		if content, ok := done[oh]; ok {
+			return content
+		}
+		active[oh] = true
+		content := oh.content
+		replaced := make(map[string]bool)
+		for _, ref := range refs[oh] {
+			if replaced[ref.URL] || active[ref.To] {
+				continue
+			}
+			replaced[ref.URL] = true
+			to := inline(ref.To)
+			if maxSize > 0 && len(to) > maxSize {
+				continue
+			}
+			uri := dataURI(ref.To.Header.Get("Content-Type"), to)
+			content = replaceURL(content, ref.URL, uri)
+		}
+		delete(active, oh)
+		done[oh] = content
+		return content
+	}
+	return inline(g.Root), nil
+}
+
+// dataURI formats content as base64 data: URI, see RFC 2397.
+func dataURI(contentType string, content []byte) string {
+	var b strings.Builder
+	b.WriteString("data:")
+	if mediaType, params, err := mime.ParseMediaType(contentType); err == nil {
+		b.WriteString(mediaType)
+		keys := make([]string, 0, len(params))
+		for k := range params {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			b.WriteString(";" + k + "=" + params[k])
+		}
+	}
+	b.WriteString(";base64,")
+	b.WriteString(base64.StdEncoding.EncodeToString(content))
+	return b.String()
+}
+
+// replaceURL replaces the occurrences of old in b which are delimited like
+// an attribute value or CSS url(), so that "a.png" doesn't match inside
+// "data.png".
+func replaceURL(b []byte, old, new string) []byte {
+	if old == "" {
+		return b
+	}
+	var out bytes.Buffer
+	o := []byte(old)
+	for {
+		i := bytes.Index(b, o)
+		if i < 0 {
+			out.Write(b)
+			return out.Bytes()
+		}
+		end := i + len(o)
+		if isURLDelim(b, i-1) && isURLDelim(b, end) {
+			out.Write(b[:i])
+			out.WriteString(new)
+		} else {
+			out.Write(b[:end])
+		}
+		b = b[end:]
+	}
+}
+
+func isURLDelim(b []byte, i int) bool {
+	if i < 0 || i >= len(b) {
+		return true
+	}
+	return strings.IndexByte("\"'()= \t\r\n,>", b[i]) >= 0
+}
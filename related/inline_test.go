@@ -0,0 +1,72 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package related
+
+import (
+	"testing"
+)
+
+func TestObjectInline(t *testing.T) {
+	params := map[string]string{"boundary": "example-3", "type": "text/html"}
+	object := readTestObject(t, testGraphBody, params, false)
+
+	got, err := object.Inline(0)
+	if err != nil {
+		t.Fatalf("Inline: %v", err)
+	}
+	// The style sheet refers back to the page, closing a cycle.
+	css := "data:text/css;base64,Ym9keSB7IGJhY2tncm91bmQ6IHVybChjaWQ6cGFnZUB4KSB9"
+	want := `<link href="` + css + `"><img src="data:image/png;base64,cG5n">` +
+		`<img src="cid:gone@x"><a href="http://example.org/">`
+	if string(got) != want {
+		t.Errorf("Inline = %s, want %s", got, want)
+	}
+
+	got, err = object.Inline(3)
+	if err != nil {
+		t.Fatalf("Inline: %v", err)
+	}
+	want = `<link href="style.css"><img src="data:image/png;base64,cG5n">` +
+		`<img src="cid:gone@x"><a href="http://example.org/">`
+	if string(got) != want {
+		t.Errorf("Inline = %s, want %s", got, want)
+	}
+
+	if _, err := (&Object{}).Inline(0); err != ErrNoRoot {
+		t.Errorf("Inline = %v, want %v", err, ErrNoRoot)
+	}
+}
+
+func TestReplaceURL(t *testing.T) {
+	tests := []struct {
+		in, old, new, w string
+	}{
+		{`src="a.png"`, "a.png", "x", `src="x"`},
+		{`src="data.png" src=a.png>`, "a.png", "x", `src="data.png" src=x>`},
+		{`url(a.png) url('a.png')`, "a.png", "x", `url(x) url('x')`},
+		{`a.png.bak`, "a.png", "x", `a.png.bak`},
+	}
+	for i, tt := range tests {
+		if g := string(replaceURL([]byte(tt.in), tt.old, tt.new)); g != tt.w {
+			t.Errorf("%d. replaceURL(%s) = %s, want %s", i, tt.in, g, tt.w)
+		}
+	}
+}
+
+func TestDataURI(t *testing.T) {
+	tests := []struct {
+		contentType, w string
+	}{
+		{"image/png", "data:image/png;base64,YQ=="},
+		{"text/css; charset=UTF-8", "data:text/css;charset=UTF-8;base64,YQ=="},
+		{"", "data:;base64,YQ=="},
+	}
+	for i, tt := range tests {
+		if g := dataURI(tt.contentType, []byte("a")); g != tt.w {
+			t.Errorf("%d. dataURI(%s) = %s, want %s", i, tt.contentType, g, tt.w)
+		}
+	}
+}
@@ -0,0 +1,334 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package related
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net/textproto"
+	"net/url"
+	"strings"
+)
+
+// Severity ranks a Diagnostic.
+type Severity int
+
+// Severities of diagnostics.
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+	SeverityInfo
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	case SeverityInfo:
+		return "info"
+	}
+	return fmt.Sprintf("Severity(%d)", int(s))
+}
+
+// A Diagnostic is a finding of Lint.
+type Diagnostic struct {
+	Severity Severity
+
+	// Rule identifies the check, e.g. "start-not-found".
+	Rule string
+
+	// Part is the index of the part on the wire, or -1 for findings
+	// concerning the whole message.
+	Part int
+
+	// Offset is the byte offset in the body the finding refers to, or -1.
+	Offset int64
+
+	Message string
+}
+
+func (d Diagnostic) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s [%s]", d.Severity, d.Rule)
+	if d.Part >= 0 {
+		fmt.Fprintf(&b, " part %d", d.Part)
+	}
+	if d.Offset >= 0 {
+		fmt.Fprintf(&b, " at offset %d", d.Offset)
+	}
+	fmt.Fprintf(&b, ": %s", d.Message)
+	return b.String()
+}
+
+// maxLineLength is the line length limit of RFC 5322 and RFC 2045,
+// excluding CRLF.
+const maxLineLength = 998
+
+// Lint checks the multipart/related body, described by contentType,
+// against RFC 2387, RFC 2045, RFC 2046, RFC 2392 and RFC 2557. It reports
+// every finding instead of stopping at the first one.
+func Lint(body []byte, contentType string) []Diagnostic {
+	l := &linter{}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		l.report(SeverityError, "content-type-syntax", -1, -1, "malformed Content-Type: %v", err)
+		return l.diags
+	}
+	if mediaType != "multipart/related" {
+		l.report(SeverityError, "media-type", -1, -1, "media type is %s, not multipart/related", mediaType)
+	}
+	boundary := params["boundary"]
+	if boundary == "" {
+		l.report(SeverityError, "boundary-missing", -1, -1, "boundary parameter is missing")
+		return l.diags
+	}
+	if !validBoundary(boundary) {
+		l.report(SeverityError, "boundary-syntax", -1, -1, "boundary %q violates RFC 2046", boundary)
+	}
+	if params["type"] == "" {
+		l.report(SeverityError, "type-missing", -1, -1, "type parameter is missing")
+	}
+	start := ""
+	if s, ok := params["start"]; ok {
+		if start = parseContentId(s); start == "" {
+			l.report(SeverityError, "start-syntax", -1, -1, "malformed start parameter %q", s)
+		}
+	}
+
+	raw := splitRaw(body, boundary)
+	if len(raw.parts) == 0 {
+		l.report(SeverityError, "no-parts", -1, -1, "no delimiter found")
+		return l.diags
+	}
+	if raw.epilogue == nil {
+		l.report(SeverityError, "close-delimiter-missing", -1, int64(len(body)), "close delimiter is missing, the message may be truncated")
+	}
+
+	offset := int64(len(raw.preamble))
+	seen := make(map[string]int)
+	rootIndex := -1
+	var parts []*lintPart
+	for i, seg := range raw.parts {
+		p := l.parsePart(i, offset, seg)
+		parts = append(parts, p)
+		offset += int64(len(seg))
+		if p.header == nil {
+			continue
+		}
+
+		if v := p.header.Get("Content-Id"); v != "" {
+			cid := parseContentId(v)
+			switch {
+			case cid == "":
+				l.report(SeverityError, "content-id-syntax", i, p.offset, "malformed Content-ID %q", v)
+			case seen[cid] > 0:
+				l.report(SeverityError, "content-id-duplicate", i, p.offset, "Content-ID <%s> already used by part %d", cid, seen[cid]-1)
+			default:
+				seen[cid] = i + 1
+			}
+			if cid != "" && cid == start && rootIndex < 0 {
+				rootIndex = i
+			}
+		}
+		l.checkPart(i, p)
+	}
+
+	if start != "" && rootIndex < 0 {
+		l.report(SeverityError, "start-not-found", -1, -1, "no part has the Content-ID <%s> of the start parameter", start)
+	}
+	if start == "" {
+		rootIndex = 0
+	}
+	if rootIndex >= 0 && params["type"] != "" && parts[rootIndex].header != nil {
+		t, _, _ := mime.ParseMediaType(params["type"])
+		rt, _, _ := mime.ParseMediaType(parts[rootIndex].header.Get("Content-Type"))
+		if rt == "" {
+			rt = "text/plain"
+		}
+		if !strings.EqualFold(t, rt) {
+			l.report(SeverityError, "root-type-mismatch", rootIndex, parts[rootIndex].offset,
+				"root's media type %s doesn't match type parameter %s", rt, t)
+		}
+	}
+
+	l.checkLines(body, parts)
+	l.checkGraph(body, params, parts)
+	return l.diags
+}
+
+type linter struct {
+	diags []Diagnostic
+}
+
+func (l *linter) report(s Severity, rule string, part int, offset int64, format string, a ...interface{}) {
+	l.diags = append(l.diags, Diagnostic{
+		Severity: s,
+		Rule:     rule,
+		Part:     part,
+		Offset:   offset,
+		Message:  fmt.Sprintf(format, a...),
+	})
+}
+
+// lintPart is a part as found on the wire.
+type lintPart struct {
+	offset     int64 // of the delimiter line
+	bodyOffset int64
+	header     textproto.MIMEHeader
+	body       []byte
+}
+
+// parsePart splits the raw part seg, see rawMessage, into header and body.
+func (l *linter) parsePart(i int, offset int64, seg []byte) *lintPart {
+	p := &lintPart{offset: offset, bodyOffset: -1}
+	pos := bytes.IndexByte(seg, '\n') + 1
+	if pos == 0 {
+		l.report(SeverityError, "header-missing", i, offset, "part ends within its delimiter line")
+		return p
+	}
+	headerStart := pos
+	for {
+		next := bytes.IndexByte(seg[pos:], '\n')
+		if next < 0 {
+			l.report(SeverityError, "header-unterminated", i, offset+int64(headerStart), "header isn't followed by an empty line")
+			return p
+		}
+		line := bytes.TrimRight(seg[pos:pos+next], "\r")
+		pos += next + 1
+		if len(line) == 0 {
+			break
+		}
+	}
+
+	tr := textproto.NewReader(bufio.NewReader(bytes.NewReader(seg[headerStart:pos])))
+	header, err := tr.ReadMIMEHeader()
+	if err != nil {
+		l.report(SeverityError, "header-syntax", i, offset+int64(headerStart), "malformed header: %v", err)
+		return p
+	}
+	p.header = header
+	p.bodyOffset = offset + int64(pos)
+
+	body := seg[pos:]
+	if bytes.HasSuffix(body, []byte("\r\n")) {
+		body = body[:len(body)-2]
+	} else if bytes.HasSuffix(body, []byte("\n")) {
+		body = body[:len(body)-1]
+	}
+	p.body = body
+	return p
+}
+
+// checkPart checks the header fields and encoding of a part.
+func (l *linter) checkPart(i int, p *lintPart) {
+	if v := p.header.Get("Content-Type"); v != "" {
+		if _, _, err := mime.ParseMediaType(v); err != nil {
+			l.report(SeverityError, "content-type-syntax", i, p.offset, "malformed Content-Type %q: %v", v, err)
+		}
+	} else if i > 0 {
+		l.report(SeverityInfo, "content-type-missing", i, p.offset, "Content-Type is missing, text/plain is assumed")
+	}
+
+	if v := p.header.Get("Content-Location"); v != "" {
+		if _, err := url.Parse(strings.TrimSpace(v)); err != nil {
+			l.report(SeverityWarning, "content-location-syntax", i, p.offset, "malformed Content-Location %q", v)
+		}
+	}
+
+	switch cte := strings.ToLower(strings.TrimSpace(p.header.Get("Content-Transfer-Encoding"))); cte {
+	case "", "7bit", "8bit", "binary", "quoted-printable":
+	case "base64":
+		clean := bytes.Map(func(r rune) rune {
+			if r == '\r' || r == '\n' || r == ' ' || r == '\t' {
+				return -1
+			}
+			return r
+		}, p.body)
+		if _, err := base64.StdEncoding.DecodeString(string(clean)); err != nil {
+			l.report(SeverityError, "base64-invalid", i, p.bodyOffset, "invalid base64 body: %v", err)
+		}
+	default:
+		l.report(SeverityWarning, "transfer-encoding-unknown", i, p.offset, "unknown Content-Transfer-Encoding %q", cte)
+	}
+}
+
+// checkLines reports lines longer than maxLineLength outside binary parts.
+func (l *linter) checkLines(body []byte, parts []*lintPart) {
+	partAt := func(off int64) int {
+		for i := len(parts) - 1; i >= 0; i-- {
+			if off >= parts[i].offset {
+				return i
+			}
+		}
+		return -1
+	}
+	for off := 0; off < len(body); {
+		end := bytes.IndexByte(body[off:], '\n')
+		if end < 0 {
+			end = len(body) - off
+		}
+		line := bytes.TrimRight(body[off:off+end], "\r")
+		if len(line) > maxLineLength {
+			i := partAt(int64(off))
+			binary := i >= 0 && parts[i].header != nil &&
+				strings.EqualFold(strings.TrimSpace(parts[i].header.Get("Content-Transfer-Encoding")), "binary")
+			if !binary {
+				l.report(SeverityError, "line-too-long", i, int64(off), "line of %d octets exceeds %d", len(line), maxLineLength)
+			}
+		}
+		off += end + 1
+	}
+}
+
+// checkGraph reports dangling references, orphans and cycles.
+func (l *linter) checkGraph(body []byte, params map[string]string, parts []*lintPart) {
+	object, err := NewReader(bytes.NewReader(body), params).ReadObject()
+	if err != nil {
+		l.report(SeverityError, "parse", -1, -1, "parsing failed: %v", err)
+		return
+	}
+	g := object.Graph()
+	offsetOf := func(oh *ObjectHeader) int64 {
+		if oh.order < len(parts) {
+			return parts[oh.order].offset
+		}
+		return -1
+	}
+	r := g.Validate()
+	for _, e := range r.Unextracted {
+		l.report(SeverityWarning, "reference-extraction", e.Part.order, offsetOf(e.Part), "extracting references failed: %v", e.Err)
+	}
+	for _, ref := range r.Dangling {
+		l.report(SeverityError, "reference-dangling", ref.From.order, offsetOf(ref.From), "%s refers to a missing part", ref.URL)
+	}
+	for _, oh := range r.Orphans {
+		l.report(SeverityInfo, "part-unreferenced", oh.order, offsetOf(oh), "part isn't referenced from the root")
+	}
+	for _, cycle := range r.Cycles {
+		l.report(SeverityWarning, "reference-cycle", cycle[0].order, offsetOf(cycle[0]), "reference cycle of %d parts", len(cycle))
+	}
+}
+
+// validBoundary checks the boundary syntax of RFC 2046 section 5.1.1.
+func validBoundary(b string) bool {
+	if len(b) < 1 || len(b) > 70 || b[len(b)-1] == ' ' {
+		return false
+	}
+	for _, c := range b {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		case strings.ContainsRune("'()+_,-./:=? ", c):
+		default:
+			return false
+		}
+	}
+	return true
+}
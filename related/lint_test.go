@@ -0,0 +1,98 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package related
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func lintRules(diags []Diagnostic) []string {
+	var rules []string
+	for _, d := range diags {
+		rules = append(rules, d.Rule)
+	}
+	return rules
+}
+
+func TestLint(t *testing.T) {
+	const ct = `multipart/related; boundary=example-1; start="<a@b.c>"; type="a/b"`
+	tests := []struct {
+		body        string
+		contentType string
+		rules       []string
+	}{
+		{testBody, ct, nil},
+		{testBody, "multipart/related; boundary=example-1; type=\"b/c\"", []string{"root-type-mismatch", "part-unreferenced"}},
+		{testBody, "multipart/related; boundary=example-1", []string{"type-missing", "part-unreferenced"}},
+		{testBody, `multipart/related; boundary=example-1; start="<x@y.z>"; type="a/b"`,
+			[]string{"start-not-found", "part-unreferenced", "part-unreferenced"}},
+		{testBody, `multipart/mixed; boundary=example-1; type="a/b"`, []string{"media-type", "part-unreferenced"}},
+		{testBody, "multipart/related", []string{"boundary-missing"}},
+		{testBody, `multipart/related; boundary="a b "; type="a/b"`, []string{"boundary-syntax", "no-parts"}},
+		{testBody, ";", []string{"content-type-syntax"}},
+		{testDupBody, ct, []string{"content-id-duplicate", "parse"}},
+		{strings.TrimSuffix(testBody, "--example-1--"), ct, []string{"close-delimiter-missing", "parse"}},
+		{strings.Replace(testBody, "RG9u", "RG9!", 1), ct, []string{"base64-invalid", "parse"}},
+		{strings.Replace(testBody, "<b@c.d>", "<bcd>", 1), ct, []string{"content-id-syntax", "part-unreferenced"}},
+		{strings.Replace(testBody, "Base64", "x-uuencode", 1), ct, []string{"transfer-encoding-unknown", "part-unreferenced"}},
+		{strings.Replace(testBody, "Life?", strings.Repeat("x", 999), 1), ct, []string{"line-too-long", "part-unreferenced"}},
+	}
+	for i, tt := range tests {
+		diags := Lint([]byte(tt.body), tt.contentType)
+		got := lintRules(diags)
+		if tt.rules == nil {
+			got = nil
+			for _, d := range diags {
+				if d.Severity != SeverityInfo {
+					got = append(got, d.Rule)
+				}
+			}
+		}
+		if !reflect.DeepEqual(got, tt.rules) {
+			t.Errorf("%d. Lint = %q, want %q", i, got, tt.rules)
+		}
+	}
+}
+
+func TestLintOffsets(t *testing.T) {
+	ct := `multipart/related; boundary=example-1; start="<a@b.c>"; type="a/b"`
+	body := strings.Replace(testBody, "RG9u", "RG9!", 1)
+	diags := Lint([]byte(body), ct)
+	if len(diags) == 0 {
+		t.Fatal("Lint: no diagnostics")
+	}
+	d := diags[0]
+	want := int64(strings.Index(body, "RG9!"))
+	if d.Rule != "base64-invalid" || d.Part != 1 || d.Offset != want || d.Severity != SeverityError {
+		t.Errorf("Lint = %v, want base64-invalid in part 1 at offset %d", d, want)
+	}
+	if g, w := d.String(), fmt.Sprintf("error [base64-invalid] part 1 at offset %d: ", want); !strings.HasPrefix(g, w) {
+		t.Errorf("String = %q, want prefix %q", g, w)
+	}
+}
+
+func TestValidBoundary(t *testing.T) {
+	tests := []struct {
+		b  string
+		ok bool
+	}{
+		{"example-1", true},
+		{"'()+_,-./:=? x", true},
+		{strings.Repeat("a", 70), true},
+		{strings.Repeat("a", 71), false},
+		{"trailing ", false},
+		{"", false},
+		{"ungültig", false},
+	}
+	for i, tt := range tests {
+		if g := validBoundary(tt.b); g != tt.ok {
+			t.Errorf("%d. validBoundary(%q) = %t, want %t", i, tt.b, g, tt.ok)
+		}
+	}
+}
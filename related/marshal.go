@@ -0,0 +1,372 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package related
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/textproto"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Errors introduced by Marshal and Unmarshal.
+var (
+	ErrInvalidTarget   = errors.New("target must be a non-nil pointer to a struct")
+	ErrUnsupportedType = errors.New("unsupported field type")
+	ErrMissingPart     = errors.New("required part is missing")
+)
+
+var (
+	bytesType  = reflect.TypeOf([]byte(nil))
+	readerType = reflect.TypeOf((*io.Reader)(nil)).Elem()
+)
+
+// Marshal returns the multipart/related encoding of v together with the
+// Content-Type, including boundary, start and type, of the compound
+// object.
+//
+// Only exported struct fields with a "related" tag are encoded. The tag
+// is a comma-separated list of options:
+//
+//	root        the field is the compound object's root
+//	cid=ID      the part's Content-ID
+//	type=TYPE   the part's media type
+//	omitempty   the part is optional and skipped when empty
+//
+// Fields implementing PartMarshaler encode themselves. Fields of type
+// []byte, string and io.Reader are written as they are, io.Reader fields
+// are streamed into the part. Any other type is encoded with the codec
+// registered for the part's media type, which defaults to
+// application/json. Slice fields produce one part per element, numbering
+// the Content-ID as "id-N@domain"; an empty slice produces no part.
+//
+// Unmarshal decodes io.Reader fields of type io.Reader, *bytes.Reader,
+// *bytes.Buffer or *strings.Reader, or an interface one of these
+// satisfies. Other implementations of io.Reader must implement
+// PartUnmarshaler to be decoded.
+func Marshal(v interface{}) ([]byte, string, error) {
+	var b bytes.Buffer
+	w := NewWriter(&b)
+	if err := w.Encode(v); err != nil {
+		return nil, "", err
+	}
+	if err := w.Close(); err != nil {
+		return nil, "", err
+	}
+	return b.Bytes(), w.FormDataContentType(), nil
+}
+
+// Unmarshal parses the multipart/related body r, described by params, and
+// stores the parts in the struct pointed to by v. See Marshal for the
+// struct tags.
+func Unmarshal(r io.Reader, params map[string]string, v interface{}) error {
+	return NewReader(r, params).Decode(v)
+}
+
+// Encode writes the tagged fields of the struct v as parts. The root field
+// is written first; the remaining fields follow in declaration order.
+// Encode doesn't close the Writer.
+func (w *Writer) Encode(v interface{}) error {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return ErrInvalidTarget
+	}
+	fields, err := typeFields(rv.Type())
+	if err != nil {
+		return err
+	}
+
+	sort.SliceStable(fields, func(i, j int) bool {
+		return fields[i].root && !fields[j].root
+	})
+	for _, f := range fields {
+		fv := rv.Field(f.index)
+		if f.omitEmpty && isEmptyValue(fv) {
+			continue
+		}
+		if !f.multi {
+			if err := w.encodePart(f, f.contentId, fv); err != nil {
+				return err
+			}
+			continue
+		}
+		for i := 0; i < fv.Len(); i++ {
+			cid := elementContentId(f.contentId, i)
+			if err := w.encodePart(f, cid, fv.Index(i)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (w *Writer) encodePart(f *field, contentId string, v reflect.Value) error {
+	header, body, err := encodeValue(v, f.mediaType)
+	if err != nil {
+		return fmt.Errorf("field %s: %w", f.name, err)
+	}
+
+	var pw io.Writer
+	if f.root {
+		pw, err = w.CreateRoot(contentId, header.Get("Content-Type"), header)
+	} else {
+		pw, err = w.CreatePart(contentId, header)
+	}
+	if err != nil {
+		return fmt.Errorf("field %s: %w", f.name, err)
+	}
+	_, err = io.Copy(pw, body)
+	return err
+}
+
+// Decode reads all remaining parts and stores them in the struct pointed
+// to by v. The root part is assigned to the root field, parts with a
+// Content-ID to the field carrying it and the remaining parts, in order,
+// to the fields without Content-ID. io.Reader fields read from an
+// in-memory copy of the part. A missing part is an error unless its field
+// is tagged omitempty or is a slice, which is then left untouched.
+func (r *Reader) Decode(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return ErrInvalidTarget
+	}
+	rv = rv.Elem()
+	fields, err := typeFields(rv.Type())
+	if err != nil {
+		return err
+	}
+
+	var parts []*decodedPart
+	for {
+		p, err := r.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		body, err := ioutil.ReadAll(p)
+		if err != nil {
+			return err
+		}
+		parts = append(parts, &decodedPart{
+			header:    p.Header,
+			body:      body,
+			root:      p.Root,
+			contentId: parseContentId(p.Header.Get("Content-Id")),
+		})
+	}
+
+	matches := make(map[*field][]*decodedPart)
+	for _, f := range fields {
+		for _, p := range parts {
+			if p.claimed {
+				continue
+			}
+			switch {
+			case f.root:
+				if !p.root {
+					continue
+				}
+			case f.contentId == "":
+				continue
+			case f.multi:
+				if elementIndex(f.contentId, p.contentId) < 0 {
+					continue
+				}
+			case f.contentId != p.contentId:
+				continue
+			}
+			p.claimed = true
+			matches[f] = append(matches[f], p)
+			if !f.multi {
+				break
+			}
+		}
+		if f.multi && f.contentId != "" {
+			ps := matches[f]
+			sort.SliceStable(ps, func(i, j int) bool {
+				return elementIndex(f.contentId, ps[i].contentId) <
+					elementIndex(f.contentId, ps[j].contentId)
+			})
+		}
+	}
+	for _, f := range fields {
+		if f.root || f.contentId != "" {
+			continue
+		}
+		for _, p := range parts {
+			if p.claimed {
+				continue
+			}
+			p.claimed = true
+			matches[f] = append(matches[f], p)
+			if !f.multi {
+				break
+			}
+		}
+	}
+
+	for _, f := range fields {
+		ps := matches[f]
+		if len(ps) == 0 {
+			if f.omitEmpty || f.multi {
+				continue
+			}
+			return fmt.Errorf("field %s: %w", f.name, ErrMissingPart)
+		}
+		fv := rv.Field(f.index)
+		if !f.multi {
+			if err := decodeValue(fv, ps[0].part(), ps[0].mediaType(f)); err != nil {
+				return fmt.Errorf("field %s: %w", f.name, err)
+			}
+			continue
+		}
+		slice := reflect.MakeSlice(fv.Type(), len(ps), len(ps))
+		for i, p := range ps {
+			if err := decodeValue(slice.Index(i), p.part(), p.mediaType(f)); err != nil {
+				return fmt.Errorf("field %s: %w", f.name, err)
+			}
+		}
+		fv.Set(slice)
+	}
+	return nil
+}
+
+// decodedPart is a buffered part awaiting assignment to a field.
+type decodedPart struct {
+	header    textproto.MIMEHeader
+	body      []byte
+	root      bool
+	contentId string
+	claimed   bool
+}
+
+// part returns a Part reading from the buffered body.
+func (p *decodedPart) part() *Part {
+	return &Part{Header: p.header, Root: p.root, r: bytes.NewReader(p.body)}
+}
+
+// mediaType prefers the media type of the field's tag over the one of the
+// part.
+func (p *decodedPart) mediaType(f *field) string {
+	if f.mediaType != "" {
+		return f.mediaType
+	}
+	return p.header.Get("Content-Type")
+}
+
+// A field describes a tagged struct field.
+type field struct {
+	name      string
+	index     int
+	root      bool
+	contentId string
+	mediaType string
+	omitEmpty bool
+
+	// multi is set for slice fields, other than []byte, each element
+	// being a part of its own
+	multi bool
+}
+
+// typeFields returns the tagged fields of the struct type t.
+func typeFields(t reflect.Type) ([]*field, error) {
+	var fields []*field
+	rootSeen := false
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag, ok := sf.Tag.Lookup("related")
+		if !ok || tag == "-" || sf.PkgPath != "" {
+			continue
+		}
+		f := &field{name: sf.Name, index: i}
+		for _, opt := range strings.Split(tag, ",") {
+			opt = strings.TrimSpace(opt)
+			switch {
+			case opt == "":
+			case opt == "root":
+				f.root = true
+			case opt == "omitempty":
+				f.omitEmpty = true
+			case strings.HasPrefix(opt, "cid="):
+				f.contentId = strings.TrimPrefix(opt, "cid=")
+			case strings.HasPrefix(opt, "type="):
+				f.mediaType = strings.TrimPrefix(opt, "type=")
+			default:
+				return nil, fmt.Errorf("field %s: unknown tag option %q", sf.Name, opt)
+			}
+		}
+		f.multi = sf.Type.Kind() == reflect.Slice && sf.Type != bytesType
+		if f.root {
+			if rootSeen {
+				return nil, ErrDupRoot
+			}
+			if f.multi {
+				return nil, fmt.Errorf("field %s: root can't be a slice", sf.Name)
+			}
+			rootSeen = true
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// elementContentId numbers the Content-ID of a slice element.
+func elementContentId(contentId string, i int) string {
+	at := strings.LastIndex(contentId, "@")
+	if at < 0 {
+		return contentId
+	}
+	return fmt.Sprintf("%s-%d%s", contentId[:at], i, contentId[at:])
+}
+
+// elementIndex is the inverse of elementContentId. It returns -1 if
+// contentId doesn't number an element of base.
+func elementIndex(base, contentId string) int {
+	at := strings.LastIndex(base, "@")
+	if at < 0 {
+		return -1
+	}
+	prefix, suffix := base[:at]+"-", base[at:]
+	if len(contentId) <= len(prefix)+len(suffix) ||
+		!strings.HasPrefix(contentId, prefix) ||
+		!strings.HasSuffix(contentId, suffix) {
+		return -1
+	}
+	n, err := strconv.Atoi(contentId[len(prefix) : len(contentId)-len(suffix)])
+	if err != nil || n < 0 {
+		return -1
+	}
+	return n
+}
+
+func defaultMediaTypeOf(t reflect.Type) string {
+	switch {
+	case t.Kind() == reflect.String:
+		return DefaultMediaType
+	case t == bytesType, t.Implements(readerType):
+		return "application/octet-stream"
+	}
+	return "application/json"
+}
+
+// isEmptyValue reports whether v is empty in the sense of omitempty.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Slice, reflect.String, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
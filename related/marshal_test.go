@@ -0,0 +1,252 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package related
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type testMeta struct {
+	Title string `json:"title" xml:"title"`
+	Pages int    `json:"pages" xml:"pages"`
+}
+
+type testDocument struct {
+	Meta     testMeta  `related:"root,cid=meta@x,type=application/json"`
+	Thumb    []byte    `related:"cid=thumb@x,type=image/png"`
+	Note     *testMeta `related:"cid=note@x,type=application/xml,omitempty"`
+	Pages    []string  `related:"cid=page@x,type=text/plain"`
+	Stream   io.Reader `related:"cid=stream@x"`
+	Rest     [][]byte  `related:",omitempty"`
+	Ignored  string
+	Skipped  string `related:"-"`
+	internal string `related:"cid=internal@x"`
+}
+
+func TestMarshalRoundTrip(t *testing.T) {
+	in := testDocument{
+		Meta:     testMeta{"Marvin", 2},
+		Thumb:    []byte{0x89, 'P', 'N', 'G'},
+		Pages:    []string{"Life?", "Don't talk to me about life!"},
+		Stream:   strings.NewReader("Brain the size of a planet"),
+		Ignored:  "ignored",
+		Skipped:  "skipped",
+		internal: "internal",
+	}
+
+	body, contentType, err := Marshal(&in)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		t.Fatalf("ParseMediaType: %v", err)
+	}
+	if mediaType != "multipart/related" {
+		t.Errorf("mediaType = %s, want multipart/related", mediaType)
+	}
+	if g, w := params["start"], "<meta@x>"; g != w {
+		t.Errorf("start = %s, want %s", g, w)
+	}
+	if g, w := params["type"], "application/json"; g != w {
+		t.Errorf("type = %s, want %s", g, w)
+	}
+
+	var out testDocument
+	if err := Unmarshal(bytes.NewReader(body), params, &out); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if out.Meta != in.Meta {
+		t.Errorf("Meta = %+v, want %+v", out.Meta, in.Meta)
+	}
+	if !bytes.Equal(out.Thumb, in.Thumb) {
+		t.Errorf("Thumb = %q, want %q", out.Thumb, in.Thumb)
+	}
+	if out.Note != nil {
+		t.Errorf("Note = %+v, want nil", out.Note)
+	}
+	if !reflect.DeepEqual(out.Pages, in.Pages) {
+		t.Errorf("Pages = %q, want %q", out.Pages, in.Pages)
+	}
+	stream, err := ioutil.ReadAll(out.Stream)
+	if err != nil {
+		t.Fatalf("Stream: %v", err)
+	}
+	if g, w := string(stream), "Brain the size of a planet"; g != w {
+		t.Errorf("Stream = %q, want %q", g, w)
+	}
+	if out.Rest != nil || out.Ignored != "" || out.Skipped != "" || out.internal != "" {
+		t.Errorf("unexpected fields: %+v", out)
+	}
+}
+
+func TestMarshalOptional(t *testing.T) {
+	in := testDocument{
+		Meta:   testMeta{"Marvin", 1},
+		Thumb:  []byte("png"),
+		Note:   &testMeta{"Arthur", 42},
+		Pages:  []string{"Life?"},
+		Stream: strings.NewReader(""),
+		Rest:   [][]byte{[]byte("a"), []byte("b")},
+	}
+	body, contentType, err := Marshal(in)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	_, params, _ := mime.ParseMediaType(contentType)
+
+	var out testDocument
+	if err := Unmarshal(bytes.NewReader(body), params, &out); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if out.Note == nil || *out.Note != *in.Note {
+		t.Errorf("Note = %+v, want %+v", out.Note, in.Note)
+	}
+	if !reflect.DeepEqual(out.Rest, in.Rest) {
+		t.Errorf("Rest = %q, want %q", out.Rest, in.Rest)
+	}
+}
+
+func TestMarshalReaders(t *testing.T) {
+	type document struct {
+		Root   string          `related:"root"`
+		Buffer *bytes.Buffer   `related:"cid=buffer@x"`
+		String *strings.Reader `related:"cid=string@x"`
+		Seeker io.ReadSeeker   `related:"cid=seeker@x"`
+		Pages  []string        `related:"cid=page@x"`
+	}
+	in := document{
+		Root:   "Life?",
+		Buffer: bytes.NewBufferString("Don't talk"),
+		String: strings.NewReader("to me"),
+		Seeker: strings.NewReader("about life!"),
+		Pages:  []string{},
+	}
+	body, contentType, err := Marshal(&in)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	_, params, _ := mime.ParseMediaType(contentType)
+
+	var out document
+	if err := Unmarshal(bytes.NewReader(body), params, &out); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	for _, tt := range []struct {
+		r    io.Reader
+		want string
+	}{
+		{out.Buffer, "Don't talk"},
+		{out.String, "to me"},
+		{out.Seeker, "about life!"},
+	} {
+		b, err := ioutil.ReadAll(tt.r)
+		if err != nil || string(b) != tt.want {
+			t.Errorf("%T = %q, %v, want %q", tt.r, b, err, tt.want)
+		}
+	}
+	if len(out.Pages) != 0 {
+		t.Errorf("Pages = %q, want none", out.Pages)
+	}
+
+	var file struct {
+		Root string   `related:"root"`
+		File *os.File `related:"cid=b@c.d"`
+	}
+	err = Unmarshal(strings.NewReader(testBody), testParams, &file)
+	if !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("Unmarshal *os.File = %v, want %v", err, ErrUnsupportedType)
+	}
+}
+
+func TestUnmarshalMissingPart(t *testing.T) {
+	var out struct {
+		Root  string `related:"root"`
+		Thumb []byte `related:"cid=thumb@x"`
+	}
+	err := Unmarshal(strings.NewReader(testBody), testParams, &out)
+	if !errors.Is(err, ErrMissingPart) {
+		t.Errorf("Unmarshal = %v, want %v", err, ErrMissingPart)
+	}
+}
+
+func TestUnmarshalByContentId(t *testing.T) {
+	var out struct {
+		Root  string `related:"root"`
+		Other string `related:"cid=b@c.d"`
+	}
+	if err := Unmarshal(strings.NewReader(testBody), testParams, &out); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if g, w := out.Root, "Life?"; g != w {
+		t.Errorf("Root = %q, want %q", g, w)
+	}
+	if g, w := out.Other, "Don't talk to me about life!"; g != w {
+		t.Errorf("Other = %q, want %q", g, w)
+	}
+}
+
+func TestMarshalFail(t *testing.T) {
+	tests := []interface{}{
+		"not a struct",
+		struct {
+			A string `related:"root"`
+			B string `related:"root"`
+		}{},
+		struct {
+			A []string `related:"root"`
+		}{},
+		struct {
+			A string `related:"bogus"`
+		}{},
+		struct {
+			A int `related:"type=image/png"`
+		}{},
+	}
+	for i, v := range tests {
+		if _, _, err := Marshal(v); err == nil {
+			t.Errorf("%d. Marshal(%#v): expected error", i, v)
+		}
+	}
+
+	var s struct{}
+	if err := Unmarshal(strings.NewReader(testBody), testParams, s); err != ErrInvalidTarget {
+		t.Errorf("Unmarshal = %v, want %v", err, ErrInvalidTarget)
+	}
+}
+
+func TestElementContentId(t *testing.T) {
+	tests := []struct {
+		base string
+		i    int
+		w    string
+	}{
+		{"page@x", 0, "page-0@x"},
+		{"a.b@c.d", 12, "a.b-12@c.d"},
+	}
+	for i, tt := range tests {
+		got := elementContentId(tt.base, tt.i)
+		if got != tt.w {
+			t.Errorf("%d. elementContentId(%s, %d) = %s; want %s", i, tt.base, tt.i, got, tt.w)
+		}
+		if n := elementIndex(tt.base, got); n != tt.i {
+			t.Errorf("%d. elementIndex(%s, %s) = %d; want %d", i, tt.base, got, n, tt.i)
+		}
+	}
+	for _, cid := range []string{"page@x", "page-@x", "page-a@x", "other-1@x"} {
+		if n := elementIndex("page@x", cid); n != -1 {
+			t.Errorf("elementIndex(page@x, %s) = %d; want -1", cid, n)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package related
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/mail"
+	"net/textproto"
+	"sort"
+)
+
+// ErrHeaderWritten is returned if a change to the Writer contradicts the
+// message header block already written.
+var ErrHeaderWritten = errors.New("message header already written")
+
+// SetMessageHeader makes the Writer write a header block before the body,
+// so that the output is a self-describing message, e.g. an MHTML file.
+// The block holds MIME-Version, the Content-Type of FormDataContentType
+// and the fields of header, e.g. Subject, Date or Content-Location.
+//
+// The header block is written with the first part, so the root has to be
+// created first, or start and type set before. SetMessageHeader must be
+// called before any parts are created.
+func (w *Writer) SetMessageHeader(header textproto.MIMEHeader) error {
+	if w.n > 0 {
+		return ErrHeaderWritten
+	}
+	h := make(textproto.MIMEHeader, len(header))
+	for k, v := range header {
+		k = textproto.CanonicalMIMEHeaderKey(k)
+		if k == "Mime-Version" || k == "Content-Type" {
+			continue
+		}
+		h[k] = v
+	}
+	h, err := encodeHeader(h)
+	if err != nil {
+		return err
+	}
+	w.header = h
+	return nil
+}
+
+// writeHeader writes the message header block, if any and not yet
+// written.
+func (w *Writer) writeHeader() error {
+	if w.header == nil || w.written != "" {
+		return nil
+	}
+	w.written = w.FormDataContentType()
+
+	header := w.header
+	if w.deterministic {
+		header = canonicalCasing(header)
+	}
+	var b bytes.Buffer
+	b.WriteString("MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&b, "Content-Type: %s\r\n", foldHeaderValue("Content-Type", w.written))
+	keys := make([]string, 0, len(header))
+	for k := range header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range header[k] {
+			fmt.Fprintf(&b, "%s: %s\r\n", k, foldHeaderValue(k, v))
+		}
+	}
+	b.WriteString("\r\n")
+	_, err := w.cw.Write(b.Bytes())
+	return err
+}
+
+// NewMessageReader reads a message header block from r, as written by a
+// Writer with SetMessageHeader, and returns a Reader for the body
+// following it. The header block is available from Header.
+func NewMessageReader(r io.Reader) (*Reader, error) {
+	msg, err := mail.ReadMessage(bufio.NewReader(r))
+	if err != nil {
+		return nil, err
+	}
+	reader, err := NewReaderFromHeader(msg.Body, msg.Header)
+	if err != nil {
+		return nil, err
+	}
+	reader.header = textproto.MIMEHeader(msg.Header)
+	return reader, nil
+}
+
+// Header returns the message header block read by NewMessageReader or
+// SniffReader, or nil.
+func (r *Reader) Header() textproto.MIMEHeader {
+	return r.header
+}
+
+// ContentType returns the multipart/related media type of the body with
+// the parameters the Reader uses, e.g. the boundary detected by
+// SniffReader.
+func (r *Reader) ContentType() string {
+	return mime.FormatMediaType("multipart/related", r.params)
+}
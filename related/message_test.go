@@ -0,0 +1,102 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package related
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+func TestMessageHeader(t *testing.T) {
+	var b bytes.Buffer
+	w := NewWriter(&b)
+	h := make(textproto.MIMEHeader)
+	h.Set("Subject", "Life")
+	h.Set("Date", "Fri, 12 Oct 1979 00:00:00 +0000")
+	h.Set("Content-Location", "http://example.com/")
+	h.Set("Content-Type", "text/plain")
+	if err := w.SetMessageHeader(h); err != nil {
+		t.Fatalf("SetMessageHeader: %v", err)
+	}
+	root, err := w.CreateRoot("a@b.c", "text/html", nil)
+	if err != nil {
+		t.Fatalf("CreateRoot: %v", err)
+	}
+	io.WriteString(root, "<p>Life?</p>")
+	if _, err := w.CreatePart("b@c.d", nil); err != nil {
+		t.Fatalf("CreatePart: %v", err)
+	}
+	if err := w.SetMessageHeader(h); err != ErrHeaderWritten {
+		t.Errorf("SetMessageHeader = %v, want %v", err, ErrHeaderWritten)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	want := "MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/related;\r\n" +
+		" boundary=" + w.Boundary() + ";\r\n" +
+		" start=\"<a@b.c>\"; type=\"text/html\"\r\n" +
+		"Content-Location: http://example.com/\r\n" +
+		"Date: Fri, 12 Oct 1979 00:00:00 +0000\r\n" +
+		"Subject: Life\r\n" +
+		"\r\n" +
+		"--" + w.Boundary() + "\r\n"
+	if !strings.HasPrefix(b.String(), want) {
+		t.Errorf("message = %q, want prefix %q", b.String(), want)
+	}
+
+	for name, newReader := range map[string]func(io.Reader) (*Reader, error){
+		"NewMessageReader": NewMessageReader,
+		"SniffReader":      SniffReader,
+	} {
+		r, err := newReader(bytes.NewReader(b.Bytes()))
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		object, err := r.ReadObject()
+		if err != nil {
+			t.Errorf("%s: ReadObject: %v", name, err)
+			continue
+		}
+		if g, w := r.Header().Get("Subject"), "Life"; g != w {
+			t.Errorf("%s: Subject = %q, want %q", name, g, w)
+		}
+		if g, w := object.Params["type"], "text/html"; g != w {
+			t.Errorf("%s: type = %q, want %q", name, g, w)
+		}
+		if len(object.Values) != 2 || string(object.Lookup("a@b.c").Bytes()) != "<p>Life?</p>" {
+			t.Errorf("%s: ReadObject = %d parts", name, len(object.Values))
+		}
+	}
+}
+
+func TestMessageHeaderErrors(t *testing.T) {
+	w := NewWriter(&bytes.Buffer{})
+	h := textproto.MIMEHeader{"Subject": {"Life\r\nBcc: marvin@example.com"}}
+	if err := w.SetMessageHeader(h); err == nil {
+		t.Errorf("SetMessageHeader accepted line break")
+	}
+
+	if err := w.SetMessageHeader(nil); err != nil {
+		t.Fatalf("SetMessageHeader: %v", err)
+	}
+	if _, err := w.CreatePart("", nil); err != nil {
+		t.Fatalf("CreatePart: %v", err)
+	}
+	if _, err := w.CreateRoot("a@b.c", "", nil); !errors.Is(err, ErrHeaderWritten) {
+		t.Errorf("CreateRoot = %v, want %v", err, ErrHeaderWritten)
+	}
+
+	if _, err := NewMessageReader(strings.NewReader("Subject: Life\r\n\r\n" + testBody)); err == nil {
+		t.Errorf("NewMessageReader accepted message without Content-Type")
+	}
+}
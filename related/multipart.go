@@ -9,8 +9,12 @@ import (
 	"bytes"
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"mime"
 	"mime/multipart"
+	"mime/quotedprintable"
 	"net/mail"
 	"net/textproto"
 	"strings"
@@ -18,10 +22,44 @@ import (
 
 var (
 	ErrDupRoot = errors.New("Detect duplicate roots")
+
+	// ErrTruncated is returned, wrapped in a PartError, if the body ends
+	// before the close delimiter. The PartError's Part is the index of
+	// the first incomplete part, i.e. the number of complete parts.
+	ErrTruncated = errors.New("message truncated before close delimiter")
+
+	// ErrNoBoundary is returned if the boundary is neither given nor
+	// found in the body.
+	ErrNoBoundary = errors.New("missing boundary")
+
+	// ErrNotRelated is returned for a Content-Type other than
+	// multipart/related.
+	ErrNotRelated = errors.New("media type is not multipart/related")
 )
 
 // Reader is an iterator over parts in a MIME multipart/related body.
 type Reader struct {
+	// KeepRaw makes the Reader retain the raw bytes of the message, so
+	// that Object.WriteTo reproduces unmodified parts byte-for-byte. The
+	// whole body is buffered in memory. KeepRaw must be set before the
+	// first call to NextPart.
+	KeepRaw bool
+
+	// OnWarning, if set, is called for each anomaly which doesn't stop
+	// parsing. Warnings are recorded in any case, see Warnings.
+	OnWarning func(Warning)
+
+	// Lenient makes NextPart return malformed parts, e.g. with broken
+	// headers or invalid base64, marked Corrupt and continue with the
+	// next delimiter, instead of failing. ReadObject skips corrupt parts
+	// with a warning. The whole body is buffered in memory. Lenient must
+	// be set before the first call to NextPart.
+	Lenient bool
+
+	// IgnoreChecksums turns off verifying parts against their
+	// Content-Length, Content-MD5 and Content-Digest, see ErrChecksum.
+	IgnoreChecksums bool
+
 	// SkipMatch controls whether a Reader matches the root body part's
 	// content-type against compound object's type
 	// SkipMatch bool
@@ -35,8 +73,42 @@ type Reader struct {
 	// startInfo provides additional information to an application
 	startInfo string
 
+	src      io.Reader
+	boundary string
+	params   map[string]string
+
+	// header is the message header block preceding the body, if read
+	header textproto.MIMEHeader
+
+	// raw is the buffered message if KeepRaw is set
+	raw *rawMessage
+
 	r        *multipart.Reader
 	rootRead bool
+
+	// offsets locates the parts read by r
+	offsets *offsetReader
+
+	// n is the number of parts read so far
+	n int
+
+	warnings []Warning
+}
+
+// A Warning describes an anomaly of a multipart/related body which
+// doesn't stop parsing.
+type Warning struct {
+	// Part is the index of the part on the wire, or -1 for anomalies
+	// concerning the whole message.
+	Part    int
+	Message string
+}
+
+func (w Warning) String() string {
+	if w.Part < 0 {
+		return w.Message
+	}
+	return fmt.Sprintf("part %d: %s", w.Part, w.Message)
 }
 
 // NewReader returns a new multipart/related Reader reading from r using the
@@ -46,7 +118,9 @@ func NewReader(
 	params map[string]string,
 ) *Reader {
 	return &Reader{
-		r:         multipart.NewReader(r, params["boundary"]),
+		src:       r,
+		boundary:  params["boundary"],
+		params:    params,
 		mediaType: params["type"],
 		start:     parseContentId(params["start"]),
 		startInfo: params["start-info"],
@@ -54,6 +128,38 @@ func NewReader(
 	}
 }
 
+// NewReaderFromContentType returns a new multipart/related Reader reading
+// from r, taking boundary, start, type and start-info from contentType,
+// e.g. the value of a Content-Type header. Parameter continuations and
+// charsets of RFC 2231 are decoded.
+func NewReaderFromContentType(r io.Reader, contentType string) (*Reader, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, err
+	}
+	if mediaType != "multipart/related" {
+		return nil, fmt.Errorf("%w: %s", ErrNotRelated, mediaType)
+	}
+	boundary := params["boundary"]
+	if boundary == "" {
+		return nil, ErrNoBoundary
+	}
+	if !validBoundary(boundary) {
+		return nil, fmt.Errorf("invalid boundary %q", boundary)
+	}
+	return NewReader(r, params), nil
+}
+
+// NewReaderFromHeader is like NewReaderFromContentType, taking the
+// Content-Type from header, e.g. an http.Header, mail.Header or
+// textproto.MIMEHeader.
+func NewReaderFromHeader(
+	r io.Reader,
+	header interface{ Get(key string) string },
+) (*Reader, error) {
+	return NewReaderFromContentType(r, header.Get("Content-Type"))
+}
+
 // A Part represents a single part in a multipart/related body
 type Part struct {
 	Header textproto.MIMEHeader
@@ -62,36 +168,87 @@ type Part struct {
 	// r is either a reader directly reading from p, or it's a wrapper
 	// around such a reader, decoding the Content-Tranfer-Encoding
 	r io.Reader
+
+	// raw is the part as found on the wire, starting with its delimiter
+	// line; only set if the Reader keeps raw bytes
+	raw []byte
+
+	// Corrupt is set for malformed parts returned by a Lenient Reader.
+	// Err holds the cause as *PartError, and Raw the part's bytes.
+	Corrupt bool
+	Err     error
+
+	// index and offset locate the part for PartError
+	index  int
+	offset int64
 }
 
 // A Object is parsed multipart/related compound object.
 type Object struct {
 	Values []*ObjectHeader
+
+	// Params holds the compound object's Content-Type parameters, i.e.
+	// boundary, start, type and start-info.
+	Params map[string]string
+
+	// raw is the buffered message if the Reader kept raw bytes
+	raw *rawMessage
 }
 
 // A ObjectHeader describes a component of the aggregate whole of a
 // multipart/related request.
 type ObjectHeader struct {
+	Header textproto.MIMEHeader
+	Root   bool
+
 	content []byte
 	i       int64 // current reading index
+
+	// order is the position of the part in the parsed message
+	order int
+
+	// raw is the part as found on the wire, see Part
+	raw []byte
 }
 
 // NextPart returns the next part in the multipart/related or and error.
-// When there are no more parts, the error io.EOF is returned.
+// When there are no more parts, the error io.EOF is returned. If the body
+// ends before the close delimiter, the error wraps ErrTruncated.
+//
+// If the Reader is Lenient, malformed parts are returned marked Corrupt
+// instead.
 func (r *Reader) NextPart() (*Part, error) {
-	wrap, err := r.r.NextPart()
+	if r.r == nil && r.raw == nil {
+		if err := r.init(); err != nil {
+			return nil, err
+		}
+	}
+	var p *Part
+	var err error
+	if r.Lenient {
+		p, err = r.nextRaw()
+	} else {
+		p, err = r.next()
+	}
 	if err != nil {
 		return nil, err
 	}
-	p := &Part{
-		Header: wrap.Header,
-		Root:   false,
+	r.n++
+	if p.Corrupt {
+		return p, nil
 	}
 
 	contentId := parseContentId(p.Header.Get("Content-Id"))
+	if v := p.Header.Get("Content-Id"); v != "" && contentId == "" {
+		r.warn(p.index, "unparseable Content-ID %q", v)
+	}
 	if r.start != "" && r.start == contentId {
 		if r.rootRead {
-			return nil, ErrDupRoot
+			if r.Lenient {
+				p.corrupt(ErrDupRoot)
+				return p, nil
+			}
+			return nil, p.error(ErrDupRoot)
 		} else {
 			p.Root = true
 			r.rootRead = true
@@ -100,30 +257,239 @@ func (r *Reader) NextPart() (*Part, error) {
 		p.Root = true
 		r.rootRead = true
 	}
-	p.r = wrap
 
-	switch strings.ToLower(p.Header.Get("Content-Transfer-Encoding")) {
+	if !p.Root && p.Header.Get("Content-Type") == "" {
+		r.warn(p.index, "missing Content-Type, text/plain is assumed")
+	}
+
+	// Content-Length counts the encoded octets, Content-MD5 and
+	// Content-Digest cover the decoded content.
+	var v *verifier
+	if !r.IgnoreChecksums {
+		if v = newVerifier(p.Header); v != nil {
+			p.r = v.count(p.r)
+		}
+	}
+
+	switch cte := strings.ToLower(strings.TrimSpace(p.Header.Get("Content-Transfer-Encoding"))); cte {
 	case "base64":
 		p.Header.Del("Content-Transfer-Encoding")
 		p.r = base64.NewDecoder(base64.StdEncoding, p.r)
 		break
 		// TODO binary reader
+	case "quoted-printable":
+		p.Header.Del("Content-Transfer-Encoding")
+		p.r = quotedprintable.NewReader(p.r)
+	case "", "7bit", "8bit", "binary":
+	default:
+		r.warn(p.index, "unknown Content-Transfer-Encoding %q, body is passed through", cte)
 	}
 	// TODO SkipMatch
 
+	if v != nil {
+		v.r = p.r
+		p.r = v
+	}
+
+	if r.Lenient {
+		b, err := ioutil.ReadAll(p.r)
+		if err != nil {
+			p.corrupt(err)
+			return p, nil
+		}
+		p.r = bytes.NewReader(b)
+	}
 	return p, nil
 }
 
+// next returns the next part of the underlying multipart reader.
+func (r *Reader) next() (*Part, error) {
+	wrap, err := r.r.NextRawPart()
+	if r.truncated(err) {
+		err = ErrTruncated
+	}
+	if err == io.EOF {
+		return nil, err
+	}
+	if err != nil {
+		return nil, &PartError{Part: r.n, Offset: r.offsets.offset(r.n), Err: err}
+	}
+	p := &Part{
+		Header: wrap.Header,
+		Root:   false,
+		r:      truncReader{wrap},
+		index:  r.n,
+		offset: r.offsets.offset(r.n),
+	}
+	if r.raw != nil && r.n < len(r.raw.parts) {
+		p.raw = r.raw.parts[r.n]
+	}
+	return p, nil
+}
+
+// nextRaw parses the next part of the buffered message on its own, so
+// that a malformed part doesn't affect the following ones.
+func (r *Reader) nextRaw() (*Part, error) {
+	m := r.raw
+	if r.n >= len(m.parts) {
+		if len(m.parts) == 0 && m.epilogue == nil {
+			return nil, &PartError{Part: 0, Offset: -1, Err: ErrTruncated}
+		}
+		return nil, io.EOF
+	}
+	p := &Part{
+		Header: make(textproto.MIMEHeader),
+		index:  r.n,
+		offset: m.offset(r.n),
+		raw:    m.parts[r.n],
+	}
+
+	// The last part of a truncated message lacks the line break
+	// preceding the close delimiter.
+	last := r.n == len(m.parts)-1 && m.epilogue == nil
+	closing := "--" + r.boundary + "--"
+	if last {
+		closing = "\r\n" + closing
+	}
+	mr := multipart.NewReader(io.MultiReader(
+		bytes.NewReader(p.raw),
+		strings.NewReader(closing),
+	), r.boundary)
+
+	var body []byte
+	wrap, err := mr.NextRawPart()
+	if err == nil {
+		p.Header = wrap.Header
+		body, err = ioutil.ReadAll(wrap)
+	}
+	if last {
+		err = ErrTruncated
+	}
+	if err != nil {
+		p.corrupt(err)
+		return p, nil
+	}
+	p.r = bytes.NewReader(body)
+	return p, nil
+}
+
+// init sets up the underlying multipart reader, buffering the message
+// first if KeepRaw is set. A Lenient Reader parses the buffered message
+// part by part instead.
+func (r *Reader) init() error {
+	if v := r.params["start"]; v != "" && r.start == "" {
+		r.warn(-1, "unparseable start parameter %q, first part is root", v)
+	}
+	if r.boundary == "" {
+		return ErrNoBoundary
+	}
+	src := r.src
+	if r.KeepRaw || r.Lenient {
+		b, err := ioutil.ReadAll(src)
+		if err != nil {
+			return err
+		}
+		r.raw = splitRaw(b, r.boundary)
+		if r.Lenient {
+			return nil
+		}
+		src = bytes.NewReader(b)
+	}
+	r.offsets = newOffsetReader(src, r.boundary)
+	r.r = multipart.NewReader(r.offsets, r.boundary)
+	return nil
+}
+
+// truncated reports whether err is caused by the body ending before the
+// close delimiter.
+func (r *Reader) truncated(err error) bool {
+	if err == nil || r.offsets.closed {
+		return false
+	}
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// Warnings returns the anomalies found so far.
+func (r *Reader) Warnings() []Warning {
+	return r.warnings
+}
+
+func (r *Reader) warn(part int, format string, a ...interface{}) {
+	w := Warning{Part: part, Message: fmt.Sprintf(format, a...)}
+	r.warnings = append(r.warnings, w)
+	if r.OnWarning != nil {
+		r.OnWarning(w)
+	}
+}
+
 // Read reads the body of a part, after its headers and before the next
 // part (if any) begins. It's a wrapper around multipart's Part.Read()
+//
+// Errors other than io.EOF, e.g. of decoding base64, are returned as
+// *PartError. If the body ends within the part, the error wraps
+// ErrTruncated.
 func (p *Part) Read(d []byte) (n int, err error) {
-	return p.r.Read(d)
+	if p.Corrupt {
+		return 0, p.Err
+	}
+	n, err = p.r.Read(d)
+	if err != nil && err != io.EOF {
+		err = p.error(err)
+	}
+	return
+}
+
+// truncReader reads the body of a multipart part, reporting ErrTruncated
+// if the message ends within it. Only this reader's io.ErrUnexpectedEOF
+// means truncation, a decoder's means malformed content.
+type truncReader struct {
+	r io.Reader
+}
+
+func (t truncReader) Read(d []byte) (int, error) {
+	n, err := t.r.Read(d)
+	if err == io.ErrUnexpectedEOF {
+		err = ErrTruncated
+	}
+	return n, err
+}
+
+// Raw returns the part as found on the wire, starting with its delimiter
+// line. It's only available if the Reader keeps raw bytes or is Lenient.
+func (p *Part) Raw() []byte {
+	return p.raw
+}
+
+// corrupt marks p as Corrupt with the given cause.
+func (p *Part) corrupt(err error) {
+	p.Corrupt = true
+	p.Err = p.error(err)
+}
+
+// error wraps err in a PartError locating p.
+func (p *Part) error(err error) error {
+	if _, ok := err.(*PartError); ok {
+		return err
+	}
+	return &PartError{
+		Part:      p.index,
+		ContentId: parseContentId(p.Header.Get("Content-Id")),
+		Offset:    p.offset,
+		Err:       err,
+	}
 }
 
-// ReadObject parses an entire multipart/related message.
+// ReadObject parses an entire multipart/related message. A body ending
+// before the close delimiter yields an error wrapping ErrTruncated.
 func (r *Reader) ReadObject() (*Object, error) {
-	object := &Object{[]*ObjectHeader{}}
-	for {
+	object := &Object{
+		Values: []*ObjectHeader{},
+		Params: make(map[string]string),
+	}
+	for k, v := range r.params {
+		object.Params[k] = v
+	}
+	for order := 0; ; order++ {
 		p, err := r.NextPart()
 		if err == io.EOF {
 			break
@@ -131,6 +497,10 @@ func (r *Reader) ReadObject() (*Object, error) {
 		if err != nil {
 			return nil, err
 		}
+		if p.Corrupt {
+			r.warn(p.index, "corrupt part skipped: %v", errors.Unwrap(p.Err))
+			continue
+		}
 		var b bytes.Buffer
 
 		if _, err := io.Copy(&b, p); err != nil {
@@ -138,7 +508,11 @@ func (r *Reader) ReadObject() (*Object, error) {
 		}
 
 		oh := &ObjectHeader{
+			Header:  p.Header,
+			Root:    p.Root,
 			content: b.Bytes(),
+			order:   order,
+			raw:     p.raw,
 		}
 		if p.Root {
 			object.Values = append([]*ObjectHeader{oh}, object.Values...)
@@ -147,6 +521,9 @@ func (r *Reader) ReadObject() (*Object, error) {
 		}
 
 	}
+	if r.KeepRaw {
+		object.raw = r.raw
+	}
 
 	return object, nil
 }
@@ -164,6 +541,24 @@ func (oh *ObjectHeader) Read(b []byte) (n int, err error) {
 	return
 }
 
+// Bytes returns the content of a ObjectHeader.
+func (oh *ObjectHeader) Bytes() []byte {
+	return oh.content
+}
+
+// Part returns a Part reading the content of a ObjectHeader from the
+// beginning.
+func (oh *ObjectHeader) Part() *Part {
+	return &Part{
+		Header: oh.Header,
+		Root:   oh.Root,
+		r:      bytes.NewReader(oh.content),
+		raw:    oh.raw,
+		index:  oh.order,
+		offset: -1,
+	}
+}
+
 func parseContentId(contentId string) string {
 	addr, err := mail.ParseAddress(contentId)
 	if err != nil {
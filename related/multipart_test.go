@@ -7,7 +7,13 @@ package related
 
 import (
 	"bytes"
+	"errors"
 	"io"
+	"io/ioutil"
+	"net/http"
+	"net/mail"
+	"net/textproto"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -104,7 +110,7 @@ func TestDuplicateRoots(t *testing.T) {
 	}
 	// Part 2
 	part, err = reader.NextPart()
-	if err != ErrDupRoot {
+	if !errors.Is(err, ErrDupRoot) {
 		t.Errorf("Expected error = %v, want %v", err, ErrDupRoot)
 	}
 }
@@ -239,3 +245,272 @@ func TestParseContentId(t *testing.T) {
 		}
 	}
 }
+
+var testWarningsBody = `--example-1
+Content-Type: a/b
+Content-ID: <a@b.c>
+
+Life?
+--example-1
+Content-Transfer-Encoding: x-uuencode
+Content-ID: <bcd>
+
+Don't talk to me about life!
+--example-1--`
+
+func TestReaderWarnings(t *testing.T) {
+	reader := NewReader(strings.NewReader(testWarningsBody), map[string]string{
+		"boundary": "example-1",
+		"start":    "<abc>",
+	})
+	var called []Warning
+	reader.OnWarning = func(w Warning) {
+		called = append(called, w)
+	}
+	object, err := reader.ReadObject()
+	if err != nil {
+		t.Fatalf("ReadObject: %v", err)
+	}
+	if len(object.Values) != 2 {
+		t.Errorf("ReadObject = %d parts, want 2", len(object.Values))
+	}
+
+	want := []string{
+		`unparseable start parameter "<abc>", first part is root`,
+		`part 1: unparseable Content-ID "<bcd>"`,
+		`part 1: missing Content-Type, text/plain is assumed`,
+		`part 1: unknown Content-Transfer-Encoding "x-uuencode", body is passed through`,
+	}
+	var got []string
+	for _, w := range reader.Warnings() {
+		got = append(got, w.String())
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Warnings = %q, want %q", got, want)
+	}
+	if !reflect.DeepEqual(called, reader.Warnings()) {
+		t.Errorf("OnWarning got %v, want %v", called, reader.Warnings())
+	}
+}
+
+func TestTruncated(t *testing.T) {
+	params := map[string]string{"boundary": "b"}
+	tests := []struct {
+		body     string
+		complete int
+	}{
+		{"", 0},
+		{"preamble", 0},
+		{"--b\r\n\r\nx", 0},
+		{"--b\r\n\r\nx\r\n", 0},
+		{"--b\r\n\r\nx\r\n--b", 1},
+		{"--b\r\n\r\nx\r\n--b\r\n", 1},
+		{"--b\r\n\r\nx\r\n--b\r\nContent-Type: a", 1},
+		{"--b\r\n\r\nx\r\n--b\r\n\r\ny\r\n--b-", 1},
+	}
+	for i, tt := range tests {
+		_, err := NewReader(strings.NewReader(tt.body), params).ReadObject()
+		var perr *PartError
+		if !errors.Is(err, ErrTruncated) || !errors.As(err, &perr) {
+			t.Errorf("%d. ReadObject = %v, want %v", i, err, ErrTruncated)
+			continue
+		}
+		if perr.Part != tt.complete {
+			t.Errorf("%d. complete parts = %d, want %d", i, perr.Part, tt.complete)
+		}
+	}
+
+	object, err := NewReader(strings.NewReader("--b\r\n\r\nx\r\n--b--"), params).ReadObject()
+	if err != nil || len(object.Values) != 1 {
+		t.Errorf("ReadObject = %v, %v, want 1 part", object, err)
+	}
+}
+
+func TestTruncatedBase64(t *testing.T) {
+	params := map[string]string{"boundary": "b"}
+	part := "--b\r\nContent-Transfer-Encoding: base64\r\n\r\nQUJ"
+	tests := []struct {
+		body      string
+		truncated bool
+	}{
+		{part + "\r\n--b--\r\n", false},
+		{part, true},
+	}
+	for _, tt := range tests {
+		r := NewReader(strings.NewReader(tt.body), params)
+		p, err := r.NextPart()
+		if err != nil {
+			t.Fatalf("NextPart: %v", err)
+		}
+		_, err = ioutil.ReadAll(p)
+		var perr *PartError
+		if !errors.As(err, &perr) || errors.Is(err, ErrTruncated) != tt.truncated {
+			t.Errorf("%q: Read = %v, want truncated %t", tt.body, err, tt.truncated)
+		}
+		if !tt.truncated {
+			if _, err := r.NextPart(); err != io.EOF {
+				t.Errorf("%q: NextPart = %v, want %v", tt.body, err, io.EOF)
+			}
+		}
+	}
+}
+
+var testCorruptPartsBody = "--example-1\r\n" +
+	"Content-ID: <a@b.c>\r\n" +
+	"Content-Type: a/b\r\n" +
+	"\r\n" +
+	"Life?\r\n" +
+	"--example-1\r\n" +
+	"Content-ID: <b@c.d>\r\n" +
+	"Broken header\r\n" +
+	"\r\n" +
+	"Don't talk\r\n" +
+	"--example-1\r\n" +
+	"Content-ID: <c@d.e>\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"RG9uJ3Qg!!!!\r\n" +
+	"--example-1\r\n" +
+	"Content-ID: <d@e.f>\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"to me about life!\r\n" +
+	"--example-1--\r\n"
+
+func TestLenient(t *testing.T) {
+	reader := NewReader(strings.NewReader(testCorruptPartsBody), testParams)
+	reader.Lenient = true
+
+	var corrupt []bool
+	var contents []string
+	for {
+		p, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("NextPart: %v", err)
+		}
+		corrupt = append(corrupt, p.Corrupt)
+		if !p.Corrupt {
+			b, err := ioutil.ReadAll(p)
+			if err != nil {
+				t.Fatalf("Read: %v", err)
+			}
+			contents = append(contents, string(b))
+			continue
+		}
+		if !strings.HasPrefix(string(p.Raw()), "--example-1\r\nContent-ID: ") {
+			t.Errorf("Raw = %q", p.Raw())
+		}
+		var perr *PartError
+		if !errors.As(p.Err, &perr) || perr.Part != len(corrupt)-1 {
+			t.Errorf("Err = %v, want *PartError of part %d", p.Err, len(corrupt)-1)
+		}
+		if _, err := p.Read(make([]byte, 1)); err != p.Err {
+			t.Errorf("Read = %v, want %v", err, p.Err)
+		}
+	}
+	if w := []bool{false, true, true, false}; !reflect.DeepEqual(corrupt, w) {
+		t.Errorf("Corrupt = %v, want %v", corrupt, w)
+	}
+	if w := []string{"Life?", "to me about life!"}; !reflect.DeepEqual(contents, w) {
+		t.Errorf("contents = %q, want %q", contents, w)
+	}
+
+	reader = NewReader(strings.NewReader(testCorruptPartsBody), testParams)
+	reader.Lenient = true
+	object, err := reader.ReadObject()
+	if err != nil {
+		t.Fatalf("ReadObject: %v", err)
+	}
+	if len(object.Values) != 2 || object.Lookup("d@e.f") == nil {
+		t.Errorf("ReadObject = %d parts, want a@b.c and d@e.f", len(object.Values))
+	}
+	if g := len(reader.Warnings()); g != 2 {
+		t.Errorf("Warnings = %v, want 2", reader.Warnings())
+	}
+}
+
+func TestLenientTruncated(t *testing.T) {
+	body := strings.TrimSuffix(testCorruptPartsBody, "life!\r\n--example-1--\r\n")
+	reader := NewReader(strings.NewReader(body), testParams)
+	reader.Lenient = true
+	var last *Part
+	for {
+		p, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("NextPart: %v", err)
+		}
+		last = p
+	}
+	if last == nil || !last.Corrupt || !errors.Is(last.Err, ErrTruncated) {
+		t.Fatalf("last part = %+v, want corrupt with %v", last, ErrTruncated)
+	}
+	if g, w := last.Header.Get("Content-Id"), "<d@e.f>"; g != w {
+		t.Errorf("Content-ID = %s, want %s", g, w)
+	}
+}
+
+func TestNewReaderFromContentType(t *testing.T) {
+	tests := []struct {
+		ct    string
+		start string
+		err   error
+	}{
+		{`multipart/related; boundary=example-1; start="<a@b.c>"; type="a/b"`, "a@b.c", nil},
+		{`Multipart/Related; boundary*0=exam; boundary*1="ple-1"; start*=utf-8''%3Ca%40b.c%3E`, "a@b.c", nil},
+		{`multipart/mixed; boundary=example-1`, "", ErrNotRelated},
+		{`multipart/related; type="a/b"`, "", ErrNoBoundary},
+		{`multipart/related; boundary="example-1 "`, "", errors.New("invalid boundary")},
+		{`multipart/related; boundary=`, "", errors.New("invalid media parameter")},
+	}
+	for i, tt := range tests {
+		r, err := NewReaderFromContentType(strings.NewReader(testBody), tt.ct)
+		if tt.err != nil {
+			if err == nil || !errors.Is(err, tt.err) && !strings.Contains(err.Error(), tt.err.Error()) {
+				t.Errorf("%d. NewReaderFromContentType = %v, want %v", i, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d. NewReaderFromContentType: %v", i, err)
+			continue
+		}
+		object, err := r.ReadObject()
+		if err != nil {
+			t.Errorf("%d. ReadObject: %v", i, err)
+			continue
+		}
+		if root := object.Values[0]; !root.Root || root.ContentId() != tt.start {
+			t.Errorf("%d. root = %s, want %s", i, root.ContentId(), tt.start)
+		}
+	}
+}
+
+func TestNewReaderFromHeader(t *testing.T) {
+	ct := `multipart/related; boundary=example-1; start="<b@c.d>"`
+	for _, h := range []interface{ Get(string) string }{
+		http.Header{"Content-Type": {ct}},
+		mail.Header{"Content-Type": {ct}},
+		textproto.MIMEHeader{"Content-Type": {ct}},
+	} {
+		r, err := NewReaderFromHeader(strings.NewReader(testBody), h)
+		if err != nil {
+			t.Errorf("NewReaderFromHeader(%T): %v", h, err)
+			continue
+		}
+		object, err := r.ReadObject()
+		if err != nil || object.Values[0].ContentId() != "b@c.d" {
+			t.Errorf("NewReaderFromHeader(%T): ReadObject = %v, %v", h, object, err)
+		}
+	}
+
+	if _, err := NewReader(strings.NewReader(testBody), nil).NextPart(); err != ErrNoBoundary {
+		t.Errorf("NextPart = %v, want %v", err, ErrNoBoundary)
+	}
+}
@@ -0,0 +1,218 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package related
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/textproto"
+	"sort"
+)
+
+// rawMessage is a multipart body split at its delimiter lines. Each part
+// starts with its delimiter line and ends with the line break preceding
+// the next delimiter, so concatenating preamble, parts and epilogue
+// yields the original body.
+type rawMessage struct {
+	boundary string
+	preamble []byte
+	parts    [][]byte
+
+	// epilogue starts with the close delimiter, if any
+	epilogue []byte
+}
+
+// splitRaw splits b at the delimiter lines of boundary.
+func splitRaw(b []byte, boundary string) *rawMessage {
+	delim := []byte("--" + boundary)
+	var offsets []int
+	closed := false
+	for i := 0; i < len(b) && !closed; {
+		if bytes.HasPrefix(b[i:], delim) {
+			rest := b[i+len(delim):]
+			isClose := bytes.HasPrefix(rest, []byte("--"))
+			if isClose {
+				rest = rest[2:]
+			}
+			rest = bytes.TrimLeft(rest, " \t")
+			if len(rest) == 0 || rest[0] == '\r' || rest[0] == '\n' {
+				offsets = append(offsets, i)
+				closed = isClose
+			}
+		}
+		next := bytes.IndexByte(b[i:], '\n')
+		if next < 0 {
+			break
+		}
+		i += next + 1
+	}
+
+	m := &rawMessage{boundary: boundary}
+	if len(offsets) == 0 {
+		m.preamble = b
+		return m
+	}
+	m.preamble = b[:offsets[0]]
+	end := len(b)
+	if closed {
+		end = offsets[len(offsets)-1]
+		m.epilogue = b[end:]
+		offsets = offsets[:len(offsets)-1]
+	}
+	for i, off := range offsets {
+		next := end
+		if i+1 < len(offsets) {
+			next = offsets[i+1]
+		}
+		m.parts = append(m.parts, b[off:next])
+	}
+	return m
+}
+
+// offset returns the offset of the i-th part's delimiter line.
+func (m *rawMessage) offset(i int) int64 {
+	n := len(m.preamble)
+	for _, part := range m.parts[:i] {
+		n += len(part)
+	}
+	return int64(n)
+}
+
+// ContentType returns the Content-Type of the compound object, built from
+// its Params.
+func (o *Object) ContentType() string {
+	return mime.FormatMediaType("multipart/related", o.Params)
+}
+
+// WriteTo writes the compound object as multipart/related body to w.
+//
+// If the object was read with KeepRaw, WriteTo keeps the original
+// boundary, preamble, epilogue and part order, and copies parts which
+// still carry their raw bytes as they were read. Otherwise the object is
+// written through a Writer with the boundary of Params, or a random one,
+// root first, and Params are updated to match the output.
+//
+// WriteTo refuses to write objects failing Validate.
+func (o *Object) WriteTo(w io.Writer) (int64, error) {
+	if err := o.Validate(); err != nil {
+		return 0, err
+	}
+	cw := &countWriter{w: w}
+	var err error
+	if o.raw != nil {
+		err = o.writeRaw(cw)
+	} else {
+		err = o.write(cw)
+	}
+	return cw.n, err
+}
+
+// writeRaw writes parts in their original order, delimited by the
+// original boundary.
+func (o *Object) writeRaw(w io.Writer) error {
+	values := make([]*ObjectHeader, len(o.Values))
+	copy(values, o.Values)
+	sort.SliceStable(values, func(i, j int) bool {
+		return values[i].order < values[j].order
+	})
+
+	if _, err := w.Write(o.raw.preamble); err != nil {
+		return err
+	}
+	for _, oh := range values {
+		if oh.raw != nil {
+			if _, err := w.Write(oh.raw); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := writeRawPart(w, o.raw.boundary, oh.Header, oh.content); err != nil {
+			return &PartError{Part: oh.order, ContentId: oh.ContentId(), Offset: -1, Err: err}
+		}
+	}
+	epilogue := o.raw.epilogue
+	if epilogue == nil {
+		epilogue = []byte(fmt.Sprintf("--%s--\r\n", o.raw.boundary))
+	}
+	_, err := w.Write(epilogue)
+	return err
+}
+
+// write regenerates the object through a Writer.
+func (o *Object) write(w io.Writer) error {
+	mw := NewWriter(w)
+	if b := o.Params["boundary"]; b != "" {
+		if err := mw.SetBoundary(b); err != nil {
+			return err
+		}
+	}
+	mw.SetStartInfo(o.Params["start-info"])
+	for _, oh := range o.Values {
+		if err := mw.CopyPart(oh.Part()); err != nil {
+			return err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return err
+	}
+	_, params, err := mime.ParseMediaType(mw.FormDataContentType())
+	if err != nil {
+		return err
+	}
+	o.Params = params
+	return nil
+}
+
+// writeRawPart writes a part in the layout of rawMessage, i.e. delimiter
+// line, header, body and the line break preceding the next delimiter.
+// The header is validated and encoded as by the Writer, and a body
+// containing the boundary is refused with ErrBoundaryCollision.
+func writeRawPart(w io.Writer, boundary string, header textproto.MIMEHeader, body []byte) error {
+	header, err := encodeHeader(header)
+	if err != nil {
+		return err
+	}
+	s := delimScanner{pattern: []byte("\n--" + boundary), tail: []byte("\n")}
+	if s.scan(body) > 0 {
+		return ErrBoundaryCollision
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "--%s\r\n", boundary)
+	keys := make([]string, 0, len(header))
+	for k := range header {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		for _, v := range header[k] {
+			fmt.Fprintf(&b, "%s: %s\r\n", k, foldHeaderValue(k, v))
+		}
+	}
+	b.WriteString("\r\n")
+	if _, err := w.Write(b.Bytes()); err != nil {
+		return err
+	}
+	if _, err := w.Write(body); err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, "\r\n")
+	return err
+}
+
+// countWriter counts the bytes written to w.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,241 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package related
+
+import (
+	"bytes"
+	"errors"
+	"mime"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+var testRawBody = "preamble\r\n" +
+	"--example-1  \r\n" +
+	"Content-ID: <b@c.d>\r\n" +
+	"Content-Type: b/c\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"RG9uJ3QgdGFsayB0byBtZSBhYm91dCBsaWZlIQ==\r\n" +
+	"--example-1\r\n" +
+	"content-type: a/b\r\n" +
+	"Content-ID: <a@b.c>\r\n" +
+	"\r\n" +
+	"Life?\r\n" +
+	"--example-1--\r\n" +
+	"epilogue\r\n"
+
+func TestObjectWriteToRaw(t *testing.T) {
+	r := NewReader(strings.NewReader(testRawBody), testParams)
+	r.KeepRaw = true
+	object, err := r.ReadObject()
+	if err != nil {
+		t.Fatalf("ReadObject: %v", err)
+	}
+	if g, w := string(object.Values[0].Bytes()), "Life?"; g != w {
+		t.Errorf("root = %q, want %q", g, w)
+	}
+
+	var b bytes.Buffer
+	n, err := object.WriteTo(&b)
+	if err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	if n != int64(b.Len()) {
+		t.Errorf("WriteTo = %d, wrote %d bytes", n, b.Len())
+	}
+	if g := b.String(); g != testRawBody {
+		t.Errorf("WriteTo = %q, want %q", g, testRawBody)
+	}
+
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Type", "c/d")
+	object.Values = append(object.Values, &ObjectHeader{
+		Header:  h,
+		content: []byte("Marvin"),
+		order:   len(object.Values),
+	})
+	b.Reset()
+	if _, err := object.WriteTo(&b); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	if !strings.HasPrefix(b.String(), strings.TrimSuffix(testRawBody, "--example-1--\r\nepilogue\r\n")) {
+		t.Errorf("WriteTo changed raw parts: %q", b.String())
+	}
+
+	object, err = NewReader(&b, testParams).ReadObject()
+	if err != nil {
+		t.Fatalf("ReadObject: %v", err)
+	}
+	if len(object.Values) != 3 {
+		t.Fatalf("ReadObject = %d parts, want 3", len(object.Values))
+	}
+	if g, w := string(object.Values[2].Bytes()), "Marvin"; g != w {
+		t.Errorf("part 3 = %q, want %q", g, w)
+	}
+}
+
+func TestObjectWriteToRawUnsafe(t *testing.T) {
+	tests := []struct {
+		header  textproto.MIMEHeader
+		content string
+		want    error
+	}{
+		{
+			textproto.MIMEHeader{"Content-Type": {"text/plain\r\n\r\n--example-1\r\nContent-Type: evil/x"}},
+			"Marvin", ErrInvalidHeader,
+		},
+		{
+			textproto.MIMEHeader{"Content-Type": {"text/plain"}},
+			"Marvin\r\n--example-1\r\nContent-Type: evil/x\r\n\r\n", ErrBoundaryCollision,
+		},
+	}
+	for _, test := range tests {
+		r := NewReader(strings.NewReader(testRawBody), testParams)
+		r.KeepRaw = true
+		object, err := r.ReadObject()
+		if err != nil {
+			t.Fatalf("ReadObject: %v", err)
+		}
+		if _, err := object.Add("c@d.e", test.header, []byte(test.content)); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+		var perr *PartError
+		if _, err := object.WriteTo(&bytes.Buffer{}); !errors.Is(err, test.want) ||
+			!errors.As(err, &perr) || perr.ContentId != "c@d.e" {
+			t.Errorf("WriteTo = %v, want %v in part c@d.e", err, test.want)
+		}
+	}
+
+	r := NewReader(strings.NewReader(testRawBody), testParams)
+	r.KeepRaw = true
+	object, _ := r.ReadObject()
+	object.Add("c@d.e", textproto.MIMEHeader{
+		"Content-Type":        {"text/plain"},
+		"Content-Description": {"Grüße"},
+	}, []byte("Marvin"))
+	var b bytes.Buffer
+	if _, err := object.WriteTo(&b); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	if want := "Content-Description: =?utf-8?q?Gr=C3=BC=C3=9Fe?=\r\n"; !strings.Contains(b.String(), want) {
+		t.Errorf("WriteTo = %q, want %q", b.String(), want)
+	}
+}
+
+func TestObjectWriteTo(t *testing.T) {
+	object, err := NewReader(strings.NewReader(testBody), testParams).ReadObject()
+	if err != nil {
+		t.Fatalf("ReadObject: %v", err)
+	}
+
+	var b bytes.Buffer
+	if _, err := object.WriteTo(&b); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	mediaType, params, err := mime.ParseMediaType(object.ContentType())
+	if err != nil {
+		t.Fatalf("ParseMediaType: %v", err)
+	}
+	if mediaType != "multipart/related" {
+		t.Errorf("mediaType = %s, want multipart/related", mediaType)
+	}
+	if g, w := params["boundary"], "example-1"; g != w {
+		t.Errorf("boundary = %s, want %s", g, w)
+	}
+	if g, w := params["start"], "<a@b.c>"; g != w {
+		t.Errorf("start = %s, want %s", g, w)
+	}
+
+	copied, err := NewReader(&b, params).ReadObject()
+	if err != nil {
+		t.Fatalf("ReadObject: %v", err)
+	}
+	if len(copied.Values) != len(object.Values) {
+		t.Fatalf("ReadObject = %d parts, want %d", len(copied.Values), len(object.Values))
+	}
+	for i, oh := range object.Values {
+		c := copied.Values[i]
+		if !bytes.Equal(c.Bytes(), oh.Bytes()) {
+			t.Errorf("%d. content = %q, want %q", i, c.Bytes(), oh.Bytes())
+		}
+		if g, w := c.Header.Get("Content-Id"), oh.Header.Get("Content-Id"); g != w {
+			t.Errorf("%d. Content-ID = %s, want %s", i, g, w)
+		}
+		if c.Root != oh.Root {
+			t.Errorf("%d. root = %t, want %t", i, c.Root, oh.Root)
+		}
+	}
+}
+
+func TestSplitRaw(t *testing.T) {
+	tests := []struct {
+		body     string
+		preamble string
+		parts    []string
+		epilogue string
+	}{
+		{"--b\r\n\r\nx\r\n--b--", "", []string{"--b\r\n\r\nx\r\n"}, "--b--"},
+		{"pre\n--b\n\nx\n--bb\n--b\n\ny\n--b--\nepi", "pre\n",
+			[]string{"--b\n\nx\n--bb\n", "--b\n\ny\n"}, "--b--\nepi"},
+		{"--b\r\n\r\ntruncated", "", []string{"--b\r\n\r\ntruncated"}, ""},
+		{"no delimiter", "no delimiter", nil, ""},
+	}
+	for i, tt := range tests {
+		m := splitRaw([]byte(tt.body), "b")
+		if g := string(m.preamble); g != tt.preamble {
+			t.Errorf("%d. preamble = %q, want %q", i, g, tt.preamble)
+		}
+		if len(m.parts) != len(tt.parts) {
+			t.Errorf("%d. parts = %q, want %q", i, m.parts, tt.parts)
+			continue
+		}
+		for j := range m.parts {
+			if g := string(m.parts[j]); g != tt.parts[j] {
+				t.Errorf("%d. part %d = %q, want %q", i, j, g, tt.parts[j])
+			}
+		}
+		if g := string(m.epilogue); g != tt.epilogue {
+			t.Errorf("%d. epilogue = %q, want %q", i, g, tt.epilogue)
+		}
+	}
+}
+
+func TestObjectWriteToRawMalformed(t *testing.T) {
+	body := "--example-1\r\n" +
+		"Content-ID: <a@b.c>\r\n" +
+		"Content-Type: application/json\r\n" +
+		"\r\n" +
+		"not JSON\r\n" +
+		"--example-1--\r\n"
+	r := NewReader(strings.NewReader(body), map[string]string{
+		"boundary": "example-1",
+		"start":    "a@b.c",
+		"type":     "application/json",
+	})
+	r.KeepRaw = true
+	object, err := r.ReadObject()
+	if err != nil {
+		t.Fatalf("ReadObject: %v", err)
+	}
+
+	var b bytes.Buffer
+	if _, err := object.WriteTo(&b); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	if g := b.String(); g != body {
+		t.Errorf("WriteTo = %q, want %q", g, body)
+	}
+
+	g := object.Graph()
+	if len(g.Errors) != 1 || g.Errors[0].Part != object.Values[0] {
+		t.Errorf("Errors = %v, want the JSON part", g.Errors)
+	}
+	if report := g.Validate(); len(report.Unextracted) != 1 || report.Err() != nil {
+		t.Errorf("Validate = %+v, want 1 unextracted part and no error", report)
+	}
+}
@@ -0,0 +1,107 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package related
+
+import (
+	"bufio"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"net/textproto"
+	"os"
+	"path"
+)
+
+// sniffLen is the number of bytes considered by http.DetectContentType.
+const sniffLen = 512
+
+// WriteFS writes the regular files of fsys as parts, the file named root
+// becoming the compound object's root and the others following in lexical
+// order. Each part carries its path as Content-Location and a Content-ID
+// derived from it, so identical trees produce identical parts. Media types
+// are taken from the file extension, or sniffed from the content.
+//
+// WriteFS doesn't close the Writer.
+func (w *Writer) WriteFS(fsys fs.FS, root string) error {
+	var names []string
+	rootFound := false
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		if name == root {
+			rootFound = true
+		} else {
+			names = append(names, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if !rootFound {
+		return &fs.PathError{Op: "open", Path: root, Err: fs.ErrNotExist}
+	}
+
+	if err := w.writeFile(fsys, root, true); err != nil {
+		return err
+	}
+	for _, name := range names {
+		if err := w.writeFile(fsys, name, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteDir is WriteFS for the directory dir of the local file system.
+func (w *Writer) WriteDir(dir, root string) error {
+	return w.WriteFS(os.DirFS(dir), root)
+}
+
+func (w *Writer) writeFile(fsys fs.FS, name string, root bool) error {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	br := bufio.NewReaderSize(f, sniffLen)
+	head, err := br.Peek(sniffLen)
+	if err != nil && err != io.EOF {
+		return err
+	}
+	mediaType := DetectMediaType(name, head)
+	contentId := hashContentId([]byte(name), "fs")
+
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Location", name)
+	var pw io.Writer
+	if root {
+		pw, err = w.CreateRoot(contentId, mediaType, header)
+	} else {
+		header.Set("Content-Type", mediaType)
+		pw, err = w.CreatePart(contentId, header)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(pw, br)
+	return err
+}
+
+// DetectMediaType returns the media type of a file by its extension or,
+// failing that, its first bytes, as WriteFS does.
+func DetectMediaType(name string, head []byte) string {
+	if t := mime.TypeByExtension(path.Ext(name)); t != "" {
+		return t
+	}
+	return http.DetectContentType(head)
+}
@@ -0,0 +1,82 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package related
+
+import (
+	"bytes"
+	"io/fs"
+	"mime"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+var testFS = fstest.MapFS{
+	"index.html":     {Data: []byte(`<link href="css/style.css"><img src="img/logo">`)},
+	"css/style.css":  {Data: []byte(`body { background: url(../img/logo) }`)},
+	"img/logo":       {Data: []byte("\x89PNG\r\n\x1a\n")},
+	"img/unused.txt": {Data: []byte("Life?")},
+	"link":           {Data: []byte("index.html"), Mode: fs.ModeSymlink},
+}
+
+func packTestFS(t *testing.T) (string, string) {
+	var b bytes.Buffer
+	w := NewWriter(&b)
+	if err := w.SetBoundary("example-4"); err != nil {
+		t.Fatalf("SetBoundary: %v", err)
+	}
+	if err := w.WriteFS(testFS, "index.html"); err != nil {
+		t.Fatalf("WriteFS: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return b.String(), w.FormDataContentType()
+}
+
+func TestWriterWriteFS(t *testing.T) {
+	body, contentType := packTestFS(t)
+	if again, _ := packTestFS(t); again != body {
+		t.Errorf("WriteFS isn't deterministic")
+	}
+
+	_, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		t.Fatalf("ParseMediaType: %v", err)
+	}
+	if g, w := params["type"], "text/html; charset=utf-8"; g != w {
+		t.Errorf("type = %s, want %s", g, w)
+	}
+	object := readTestObject(t, body, params, false)
+
+	var got []string
+	for _, oh := range object.Values {
+		got = append(got, oh.Header.Get("Content-Location")+" "+oh.Header.Get("Content-Type"))
+	}
+	want := []string{
+		"index.html text/html; charset=utf-8",
+		"css/style.css text/css; charset=utf-8",
+		"img/logo image/png",
+		"img/unused.txt text/plain; charset=utf-8",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parts = %q, want %q", got, want)
+	}
+	if cid := object.Values[1].ContentId(); cid != hashContentId([]byte("css/style.css"), "fs") {
+		t.Errorf("Content-ID = %s", cid)
+	}
+
+	g := object.Graph()
+	r := g.Validate()
+	if len(r.Orphans) != 1 || r.Orphans[0] != object.Values[3] {
+		t.Errorf("Orphans = %v, want img/unused.txt", r.Orphans)
+	}
+
+	w := NewWriter(&bytes.Buffer{})
+	if err := w.WriteFS(testFS, "missing.html"); err == nil {
+		t.Errorf("WriteFS: expected error for missing root")
+	}
+}
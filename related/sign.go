@@ -0,0 +1,187 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package related
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/quotedprintable"
+	"net/textproto"
+	"sort"
+	"strings"
+)
+
+// ErrSignature is returned if a signature doesn't match the compound
+// object.
+var ErrSignature = errors.New("signature mismatch")
+
+// Canonical returns a canonical form of the compound object for signing.
+// It's independent of the boundary, transfer encodings, header folding
+// and casing, so that a signature survives re-encoding the message.
+//
+// The canonical form starts with the Content-Type line of the object
+// without boundary, its type and start taken from the root part, followed
+// by the parts in order. Each part consists of its header fields with
+// lower case names, sorted, followed by an empty line, the length of the
+// decoded body and the body itself. RFC 2047 encoded-words and RFC 2231
+// parameters are decoded, and a root without Content-Type has
+// DefaultMediaType, as written by the Writer:
+//
+//	multipart/related; start="<a@b.c>"; type="text/html"\r\n
+//	content-id:<a@b.c>\r\n
+//	content-type:text/html\r\n
+//	\r\n
+//	5\r\n
+//	Life?\r\n
+func (o *Object) Canonical() ([]byte, error) {
+	var b bytes.Buffer
+	params := make(map[string]string)
+	if v := o.Params["start-info"]; v != "" {
+		params["start-info"] = v
+	}
+	for _, oh := range o.Values {
+		if !oh.Root {
+			continue
+		}
+		if t, _, err := mime.ParseMediaType(rootContentType(oh)); err == nil {
+			params["type"] = t
+		}
+		if cid := oh.ContentId(); cid != "" {
+			params["start"] = "<" + cid + ">"
+		}
+		break
+	}
+	b.WriteString(mime.FormatMediaType("multipart/related", params))
+	b.WriteString("\r\n")
+
+	for _, oh := range o.Values {
+		content, err := decodedContent(oh)
+		if err != nil {
+			return nil, fmt.Errorf("part <%s>: %v", oh.ContentId(), err)
+		}
+		header := oh.Header
+		if oh.Root && header.Get("Content-Type") == "" {
+			header = make(textproto.MIMEHeader, len(oh.Header)+1)
+			for k, v := range oh.Header {
+				header[k] = v
+			}
+			header.Set("Content-Type", DefaultMediaType)
+		}
+		var lines []string
+		for k, vs := range header {
+			k = textproto.CanonicalMIMEHeaderKey(k)
+			if k == "Content-Transfer-Encoding" {
+				continue
+			}
+			for _, v := range vs {
+				lines = append(lines, strings.ToLower(k)+":"+canonicalHeaderValue(k, v))
+			}
+		}
+		sort.Strings(lines)
+		for _, l := range lines {
+			b.WriteString(l)
+			b.WriteString("\r\n")
+		}
+		fmt.Fprintf(&b, "\r\n%d\r\n", len(content))
+		b.Write(content)
+		b.WriteString("\r\n")
+	}
+	return b.Bytes(), nil
+}
+
+// rootContentType returns the Content-Type of the root oh, as written by
+// the Writer.
+func rootContentType(oh *ObjectHeader) string {
+	if t := oh.Header.Get("Content-Type"); t != "" {
+		return t
+	}
+	return DefaultMediaType
+}
+
+// canonicalHeaderValue unfolds a header value, decodes RFC 2047
+// encoded-words and collapses its white space. Content-Type,
+// Content-Disposition and Content-ID are normalized further if they
+// parse.
+func canonicalHeaderValue(key, value string) string {
+	if v, err := new(mime.WordDecoder).DecodeHeader(value); err == nil {
+		value = v
+	}
+	value = strings.Join(strings.Fields(value), " ")
+	switch key {
+	case "Content-Type", "Content-Disposition":
+		if t, params, err := mime.ParseMediaType(value); err == nil {
+			return mime.FormatMediaType(t, params)
+		}
+	case "Content-Id":
+		if cid, err := formatContentId(value); err == nil {
+			return cid
+		}
+	}
+	return value
+}
+
+// decodedContent returns the content of oh, decoding a quoted-printable
+// Content-Transfer-Encoding left by the Reader.
+func decodedContent(oh *ObjectHeader) ([]byte, error) {
+	if !strings.EqualFold(strings.TrimSpace(oh.Header.Get("Content-Transfer-Encoding")), "quoted-printable") {
+		return oh.Bytes(), nil
+	}
+	return ioutil.ReadAll(quotedprintable.NewReader(bytes.NewReader(oh.Bytes())))
+}
+
+// SignHMAC returns the HMAC-SHA256 of the canonical form of the compound
+// object.
+func (o *Object) SignHMAC(key []byte) ([]byte, error) {
+	c, err := o.Canonical()
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write(c)
+	return mac.Sum(nil), nil
+}
+
+// VerifyHMAC checks an HMAC-SHA256 created by SignHMAC. It returns
+// ErrSignature if mac doesn't match.
+func (o *Object) VerifyHMAC(key, mac []byte) error {
+	want, err := o.SignHMAC(key)
+	if err != nil {
+		return err
+	}
+	if !hmac.Equal(mac, want) {
+		return ErrSignature
+	}
+	return nil
+}
+
+// SignEd25519 returns the Ed25519 signature of the canonical form of the
+// compound object.
+func (o *Object) SignEd25519(key ed25519.PrivateKey) ([]byte, error) {
+	c, err := o.Canonical()
+	if err != nil {
+		return nil, err
+	}
+	return ed25519.Sign(key, c), nil
+}
+
+// VerifyEd25519 checks a signature created by SignEd25519. It returns
+// ErrSignature if sig doesn't match.
+func (o *Object) VerifyEd25519(key ed25519.PublicKey, sig []byte) error {
+	c, err := o.Canonical()
+	if err != nil {
+		return err
+	}
+	if len(key) != ed25519.PublicKeySize || !ed25519.Verify(key, c, sig) {
+		return ErrSignature
+	}
+	return nil
+}
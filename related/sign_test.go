@@ -0,0 +1,120 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package related
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"strings"
+	"testing"
+)
+
+// testReencodedBody is testBody with another boundary, folded and
+// differently cased headers, and quoted-printable instead of base64.
+var testReencodedBody = "--other\r\n" +
+	"content-type:  A/B\r\n" +
+	"content-id: <a@b.c>\r\n" +
+	"\r\n" +
+	"Life?\r\n" +
+	"--other\r\n" +
+	"Content-Type: b/c\r\n" +
+	"Content-Transfer-Encoding: quoted-printable\r\n" +
+	"Content-ID:\r\n <b@c.d>\r\n" +
+	"\r\n" +
+	"Don=27t talk to me about life!\r\n" +
+	"--other--"
+
+func TestCanonical(t *testing.T) {
+	object := readTestObject(t, testBody, testParams, false)
+	params := map[string]string{"boundary": "other", "start": "<a@b.c>", "type": "a/b"}
+	reencoded := readTestObject(t, testReencodedBody, params, false)
+
+	c, err := object.Canonical()
+	if err != nil {
+		t.Fatalf("Canonical: %v", err)
+	}
+	want := "multipart/related; start=\"<a@b.c>\"; type=\"a/b\"\r\n" +
+		"content-id:<a@b.c>\r\n" +
+		"content-type:a/b\r\n" +
+		"\r\n5\r\nLife?\r\n" +
+		"content-id:<b@c.d>\r\n" +
+		"content-type:b/c\r\n" +
+		"\r\n28\r\nDon't talk to me about life!\r\n"
+	if string(c) != want {
+		t.Errorf("Canonical = %q, want %q", c, want)
+	}
+	if rc, err := reencoded.Canonical(); err != nil || !bytes.Equal(rc, c) {
+		t.Errorf("Canonical of re-encoded = %q, %v, want %q", rc, err, c)
+	}
+
+	key := []byte("Marvin")
+	mac, err := object.SignHMAC(key)
+	if err != nil {
+		t.Fatalf("SignHMAC: %v", err)
+	}
+	if err := reencoded.VerifyHMAC(key, mac); err != nil {
+		t.Errorf("VerifyHMAC: %v", err)
+	}
+
+	priv := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{42}, ed25519.SeedSize))
+	sig, err := object.SignEd25519(priv)
+	if err != nil {
+		t.Fatalf("SignEd25519: %v", err)
+	}
+	pub := priv.Public().(ed25519.PublicKey)
+	if err := reencoded.VerifyEd25519(pub, sig); err != nil {
+		t.Errorf("VerifyEd25519: %v", err)
+	}
+
+	reencoded.Values[1].content = []byte("Don't panic!")
+	if err := reencoded.VerifyHMAC(key, mac); err != ErrSignature {
+		t.Errorf("VerifyHMAC = %v, want %v", err, ErrSignature)
+	}
+	if err := reencoded.VerifyEd25519(pub, sig); err != ErrSignature {
+		t.Errorf("VerifyEd25519 = %v, want %v", err, ErrSignature)
+	}
+}
+
+func TestCanonicalWriteTo(t *testing.T) {
+	body := "--example-1\r\n" +
+		"Content-ID: <a@b.c>\r\n" +
+		"Content-Type: text/plain\r\n" +
+		"Content-Description: Grüße\r\n" +
+		"Content-Disposition: inline; filename=\"Grüße.txt\"\r\n" +
+		"\r\n" +
+		"Life?\r\n" +
+		"--example-1--\r\n"
+	object := readTestObject(t, body, map[string]string{"boundary": "example-1"}, false)
+	key := []byte("Marvin")
+	mac, err := object.SignHMAC(key)
+	if err != nil {
+		t.Fatalf("SignHMAC: %v", err)
+	}
+
+	var b bytes.Buffer
+	if _, err := object.WriteTo(&b); err != nil {
+		t.Fatalf("WriteTo: %v", err)
+	}
+	for _, want := range []string{"=?utf-8?q?Gr=C3=BC=C3=9Fe?=", "filename*=utf-8''", `type="text/plain"`} {
+		if !strings.Contains(b.String()+object.ContentType(), want) {
+			t.Errorf("WriteTo = %q, want %q", b.String(), want)
+		}
+	}
+
+	r, err := NewReaderFromContentType(&b, object.ContentType())
+	if err != nil {
+		t.Fatalf("NewReaderFromContentType: %v", err)
+	}
+	written, err := r.ReadObject()
+	if err != nil {
+		t.Fatalf("ReadObject: %v", err)
+	}
+	if err := written.VerifyHMAC(key, mac); err != nil {
+		c1, _ := object.Canonical()
+		c2, _ := written.Canonical()
+		t.Errorf("VerifyHMAC: %v\n%q\n%q", err, c1, c2)
+	}
+}
@@ -0,0 +1,473 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package related
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrUnsupportedKey is returned by SignSMIME for keys other than RSA and
+// ECDSA.
+var ErrUnsupportedKey = errors.New("unsupported signing key")
+
+// SMIMESignatureType is the media type of a detached CMS signature.
+const SMIMESignatureType = "application/pkcs7-signature"
+
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSigningTime   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+
+	oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+
+	oidRSAEncryption   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidSHA256WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+	oidSHA384WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}
+	oidSHA512WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}
+	oidECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidECDSAWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
+	oidECDSAWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}
+)
+
+// CMS structures, see RFC 5652. Marshaling a RawValue ignores its field's
+// tag, so tagged RawValues carry their tag themselves.
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,tag:0"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo encapContentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type encapContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     asn1.RawValue `asn1:"optional,explicit,tag:0"`
+}
+
+type signerInfo struct {
+	Version            int
+	Sid                issuerAndSerialNumber
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+	UnsignedAttrs      asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type issuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue `asn1:"set"`
+}
+
+// SignSMIME writes a multipart/signed message (RFC 8551) to w. Its first
+// part is the entity of contentType and body, e.g. the output of a Writer
+// and its FormDataContentType, the second one a detached CMS signature
+// made with key, which has to be RSA or ECDSA, and cert. Intermediate
+// certificates are included for verification. SignSMIME returns the
+// Content-Type of the message.
+//
+// The entity is signed as is. Other S/MIME implementations canonicalize
+// line breaks to CRLF before verifying, as Writer output has them.
+func SignSMIME(
+	w io.Writer,
+	contentType string,
+	body []byte,
+	cert *x509.Certificate,
+	key crypto.Signer,
+	intermediates ...*x509.Certificate,
+) (string, error) {
+	var entity bytes.Buffer
+	fmt.Fprintf(&entity, "Content-Type: %s\r\n\r\n", contentType)
+	entity.Write(body)
+
+	sig, err := signCMS(entity.Bytes(), cert, key, intermediates)
+	if err != nil {
+		return "", err
+	}
+
+	boundary := multipart.NewWriter(nil).Boundary()
+	for bytes.Contains(entity.Bytes(), []byte("--"+boundary)) {
+		boundary = multipart.NewWriter(nil).Boundary()
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "--%s\r\n", boundary)
+	b.Write(entity.Bytes())
+	fmt.Fprintf(&b, "\r\n--%s\r\n", boundary)
+	fmt.Fprintf(&b, "Content-Type: %s; name=\"smime.p7s\"\r\n", SMIMESignatureType)
+	b.WriteString("Content-Transfer-Encoding: base64\r\n")
+	b.WriteString("Content-Disposition: attachment; filename=\"smime.p7s\"\r\n\r\n")
+	encoded := base64.StdEncoding.EncodeToString(sig)
+	for len(encoded) > 76 {
+		b.WriteString(encoded[:76] + "\r\n")
+		encoded = encoded[76:]
+	}
+	b.WriteString(encoded + "\r\n")
+	fmt.Fprintf(&b, "--%s--\r\n", boundary)
+	if _, err := w.Write(b.Bytes()); err != nil {
+		return "", err
+	}
+
+	return mime.FormatMediaType("multipart/signed", map[string]string{
+		"protocol": SMIMESignatureType,
+		"micalg":   "sha-256",
+		"boundary": boundary,
+	}), nil
+}
+
+// signCMS returns a DER encoded CMS SignedData of content, without
+// encapsulating it.
+func signCMS(
+	content []byte,
+	cert *x509.Certificate,
+	key crypto.Signer,
+	intermediates []*x509.Certificate,
+) ([]byte, error) {
+	var sigAlg pkix.AlgorithmIdentifier
+	switch key.Public().(type) {
+	case *rsa.PublicKey:
+		sigAlg = pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue}
+	case *ecdsa.PublicKey:
+		sigAlg = pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA256}
+	default:
+		return nil, ErrUnsupportedKey
+	}
+
+	digest := crypto.SHA256.New()
+	digest.Write(content)
+	attrs, err := marshalAttributes([]attribute{
+		newAttribute(oidContentType, oidData),
+		newAttribute(oidMessageDigest, digest.Sum(nil)),
+		newAttribute(oidSigningTime, time.Now().UTC()),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The signature covers the signed attributes as SET OF, see RFC 5652,
+	// section 5.4.
+	h := crypto.SHA256.New()
+	h.Write(attrs.FullBytes)
+	signature, err := key.Sign(rand.Reader, h.Sum(nil), crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	certs := cert.Raw
+	for _, c := range intermediates {
+		certs = append(certs[:len(certs):len(certs)], c.Raw...)
+	}
+	sha256Alg := pkix.AlgorithmIdentifier{Algorithm: oidSHA256}
+	sd, err := asn1.Marshal(signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{sha256Alg},
+		EncapContentInfo: encapContentInfo{EContentType: oidData},
+		Certificates: asn1.RawValue{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        0,
+			IsCompound: true,
+			Bytes:      certs,
+		},
+		SignerInfos: []signerInfo{{
+			Version: 1,
+			Sid: issuerAndSerialNumber{
+				Issuer:       asn1.RawValue{FullBytes: cert.RawIssuer},
+				SerialNumber: cert.SerialNumber,
+			},
+			DigestAlgorithm: sha256Alg,
+			SignedAttrs: asn1.RawValue{
+				Class:      asn1.ClassContextSpecific,
+				Tag:        0,
+				IsCompound: true,
+				Bytes:      attrs.Bytes,
+			},
+			SignatureAlgorithm: sigAlg,
+			Signature:          signature,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content: asn1.RawValue{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        0,
+			IsCompound: true,
+			Bytes:      sd,
+		},
+	})
+}
+
+// newAttribute returns an attribute with a single value. It panics if
+// value can't be marshaled.
+func newAttribute(oid asn1.ObjectIdentifier, value interface{}) attribute {
+	b, err := asn1.Marshal(value)
+	if err != nil {
+		panic(err)
+	}
+	return attribute{
+		Type:   oid,
+		Values: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: b},
+	}
+}
+
+// marshalAttributes encodes attrs as DER SET OF, i.e. sorted by their
+// encodings.
+func marshalAttributes(attrs []attribute) (asn1.RawValue, error) {
+	encoded := make([][]byte, len(attrs))
+	for i, a := range attrs {
+		b, err := asn1.Marshal(a)
+		if err != nil {
+			return asn1.RawValue{}, err
+		}
+		encoded[i] = b
+	}
+	sort.Slice(encoded, func(i, j int) bool {
+		return bytes.Compare(encoded[i], encoded[j]) < 0
+	})
+	set := asn1.RawValue{
+		Class:      asn1.ClassUniversal,
+		Tag:        asn1.TagSet,
+		IsCompound: true,
+		Bytes:      bytes.Join(encoded, nil),
+	}
+	b, err := asn1.Marshal(set)
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+	set.FullBytes = b
+	return set, nil
+}
+
+// VerifySMIME verifies a multipart/signed message of the given
+// Content-Type, as written by SignSMIME. The signer's certificate has to
+// chain up to roots, using intermediates of the signature. VerifySMIME
+// returns a Reader for the signed entity and the signer's certificate. It
+// returns an error wrapping ErrSignature if the signature doesn't match.
+func VerifySMIME(
+	body []byte,
+	contentType string,
+	roots *x509.CertPool,
+) (*Reader, *x509.Certificate, error) {
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil, nil, err
+	}
+	if mediaType != "multipart/signed" {
+		return nil, nil, fmt.Errorf("media type is %s, not multipart/signed", mediaType)
+	}
+	boundary := params["boundary"]
+	if boundary == "" {
+		return nil, nil, ErrNoBoundary
+	}
+
+	// The signed entity is the first part exactly as found on the wire.
+	m := splitRaw(body, boundary)
+	if len(m.parts) != 2 {
+		return nil, nil, fmt.Errorf("multipart/signed has %d parts, want 2", len(m.parts))
+	}
+	entity := m.parts[0][bytes.IndexByte(m.parts[0], '\n')+1:]
+	entity = bytes.TrimSuffix(entity, []byte("\n"))
+	entity = bytes.TrimSuffix(entity, []byte("\r"))
+
+	mr := multipart.NewReader(bytes.NewReader(body), boundary)
+	if _, err := mr.NextPart(); err != nil {
+		return nil, nil, err
+	}
+	p, err := mr.NextPart()
+	if err != nil {
+		return nil, nil, err
+	}
+	if t, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type")); t != SMIMESignatureType && t != "application/x-pkcs7-signature" {
+		return nil, nil, fmt.Errorf("signature has media type %s", t)
+	}
+	var sr io.Reader = p
+	if strings.EqualFold(strings.TrimSpace(p.Header.Get("Content-Transfer-Encoding")), "base64") {
+		sr = base64.NewDecoder(base64.StdEncoding, p)
+	}
+	sig, err := ioutil.ReadAll(sr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cert, err := verifyCMS(sig, entity, roots)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	br := bufio.NewReader(bytes.NewReader(entity))
+	header, err := textproto.NewReader(br).ReadMIMEHeader()
+	if err != nil {
+		return nil, nil, err
+	}
+	r, err := NewReaderFromHeader(br, header)
+	if err != nil {
+		return nil, nil, err
+	}
+	r.header = header
+	return r, cert, nil
+}
+
+// verifyCMS checks a detached CMS SignedData of content and returns the
+// signer's certificate.
+func verifyCMS(der, content []byte, roots *x509.CertPool) (*x509.Certificate, error) {
+	var ci contentInfo
+	if _, err := asn1.Unmarshal(der, &ci); err != nil {
+		return nil, err
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, fmt.Errorf("CMS content type is %v, not signed data", ci.ContentType)
+	}
+	var sd signedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, err
+	}
+	if len(sd.SignerInfos) != 1 {
+		return nil, fmt.Errorf("CMS has %d signers, want 1", len(sd.SignerInfos))
+	}
+	si := sd.SignerInfos[0]
+	certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	var cert *x509.Certificate
+	intermediates := x509.NewCertPool()
+	for _, c := range certs {
+		if bytes.Equal(c.RawIssuer, si.Sid.Issuer.FullBytes) && c.SerialNumber.Cmp(si.Sid.SerialNumber) == 0 {
+			cert = c
+		} else {
+			intermediates.AddCert(c)
+		}
+	}
+	if cert == nil {
+		return nil, errors.New("signer's certificate not found")
+	}
+
+	hash, sigAlg, err := signatureAlgorithm(si.DigestAlgorithm.Algorithm, si.SignatureAlgorithm.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if len(si.SignedAttrs.Bytes) == 0 {
+		return nil, errors.New("CMS without signed attributes")
+	}
+	var attrs []attribute
+	if _, err := asn1.UnmarshalWithParams(si.SignedAttrs.FullBytes, &attrs, "set,tag:0"); err != nil {
+		return nil, err
+	}
+	var digest []byte
+	for _, a := range attrs {
+		if a.Type.Equal(oidMessageDigest) {
+			if _, err := asn1.Unmarshal(a.Values.Bytes, &digest); err != nil {
+				return nil, err
+			}
+		}
+	}
+	h := hash.New()
+	h.Write(content)
+	if !bytes.Equal(digest, h.Sum(nil)) {
+		return nil, fmt.Errorf("%w: message digest", ErrSignature)
+	}
+
+	// The signature covers the signed attributes with the SET OF tag.
+	signed := append([]byte{0x31}, si.SignedAttrs.FullBytes[1:]...)
+	if err := cert.CheckSignature(sigAlg, signed, si.Signature); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSignature, err)
+	}
+
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+	}); err != nil {
+		return nil, err
+	}
+	return cert, nil
+}
+
+// signatureAlgorithm maps CMS digest and signature algorithms to their
+// x509 counterpart.
+func signatureAlgorithm(digest, sig asn1.ObjectIdentifier) (crypto.Hash, x509.SignatureAlgorithm, error) {
+	var hash crypto.Hash
+	switch {
+	case digest.Equal(oidSHA256):
+		hash = crypto.SHA256
+	case digest.Equal(oidSHA384):
+		hash = crypto.SHA384
+	case digest.Equal(oidSHA512):
+		hash = crypto.SHA512
+	default:
+		return 0, 0, fmt.Errorf("unsupported digest algorithm %v", digest)
+	}
+
+	algs := map[crypto.Hash][2]x509.SignatureAlgorithm{
+		crypto.SHA256: {x509.SHA256WithRSA, x509.ECDSAWithSHA256},
+		crypto.SHA384: {x509.SHA384WithRSA, x509.ECDSAWithSHA384},
+		crypto.SHA512: {x509.SHA512WithRSA, x509.ECDSAWithSHA512},
+	}[hash]
+
+	// Signature algorithms other than rsaEncryption name their hash,
+	// which has to be the digest algorithm.
+	var sigHash crypto.Hash
+	alg := -1
+	switch {
+	case sig.Equal(oidRSAEncryption):
+		sigHash, alg = hash, 0
+	case sig.Equal(oidSHA256WithRSA):
+		sigHash, alg = crypto.SHA256, 0
+	case sig.Equal(oidSHA384WithRSA):
+		sigHash, alg = crypto.SHA384, 0
+	case sig.Equal(oidSHA512WithRSA):
+		sigHash, alg = crypto.SHA512, 0
+	case sig.Equal(oidECDSAWithSHA256):
+		sigHash, alg = crypto.SHA256, 1
+	case sig.Equal(oidECDSAWithSHA384):
+		sigHash, alg = crypto.SHA384, 1
+	case sig.Equal(oidECDSAWithSHA512):
+		sigHash, alg = crypto.SHA512, 1
+	default:
+		return 0, 0, fmt.Errorf("unsupported signature algorithm %v", sig)
+	}
+	if sigHash != hash {
+		return 0, 0, fmt.Errorf("signature algorithm %v doesn't match digest algorithm %v", sig, digest)
+	}
+	return hash, algs[alg], nil
+}
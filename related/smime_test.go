@@ -0,0 +1,174 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package related
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"math/big"
+	"mime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestCert issues a certificate for key, self-signed if parent is nil.
+func newTestCert(t *testing.T, name string, key crypto.Signer, parent *x509.Certificate, parentKey crypto.Signer) *x509.Certificate {
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+	}
+	if parent == nil {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+		tmpl.ExtKeyUsage = nil
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("ParseCertificate: %v", err)
+	}
+	return cert
+}
+
+func TestSMIME(t *testing.T) {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ca := newTestCert(t, "CA", caKey, nil, nil)
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	rsaCert := newTestCert(t, "marvin@example.com", rsaKey, ca, caKey)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	var b bytes.Buffer
+	contentType, err := SignSMIME(&b, "multipart/related; boundary=example-1; start=\"<a@b.c>\"; type=\"a/b\"",
+		[]byte(testBody), rsaCert, rsaKey)
+	if err != nil {
+		t.Fatalf("SignSMIME: %v", err)
+	}
+
+	r, cert, err := VerifySMIME(b.Bytes(), contentType, roots)
+	if err != nil {
+		t.Fatalf("VerifySMIME: %v", err)
+	}
+	if cert.Subject.CommonName != "marvin@example.com" {
+		t.Errorf("signer = %s, want marvin@example.com", cert.Subject.CommonName)
+	}
+	object, err := r.ReadObject()
+	if err != nil {
+		t.Fatalf("ReadObject: %v", err)
+	}
+	if len(object.Values) != 2 || object.Values[0].ContentId() != "a@b.c" {
+		t.Errorf("ReadObject = %d parts, want root a@b.c and b@c.d", len(object.Values))
+	}
+
+	tampered := bytes.Replace(b.Bytes(), []byte("Life?"), []byte("Life!"), 1)
+	if _, _, err := VerifySMIME(tampered, contentType, roots); !errors.Is(err, ErrSignature) {
+		t.Errorf("VerifySMIME of tampered message = %v, want %v", err, ErrSignature)
+	}
+	if _, _, err := VerifySMIME(b.Bytes(), contentType, x509.NewCertPool()); err == nil {
+		t.Errorf("VerifySMIME accepted unknown authority")
+	}
+
+	// The same signature in binary Content-Transfer-Encoding
+	_, params, _ := mime.ParseMediaType(contentType)
+	closing := "\r\n--" + params["boundary"] + "--\r\n"
+	cte := "Content-Transfer-Encoding: base64\r\n"
+	i := bytes.Index(b.Bytes(), []byte(cte))
+	j := i + bytes.Index(b.Bytes()[i:], []byte("\r\n\r\n")) + 4
+	der, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(
+		strings.TrimSuffix(string(b.Bytes()[j:]), closing), "\r\n", ""))
+	if err != nil {
+		t.Fatalf("decoding signature: %v", err)
+	}
+	binary := string(b.Bytes()[:i]) + "Content-Transfer-Encoding: binary\r\n" +
+		string(b.Bytes()[i+len(cte):j]) + string(der) + closing
+	if _, _, err := VerifySMIME([]byte(binary), contentType, roots); err != nil {
+		t.Errorf("VerifySMIME of binary signature: %v", err)
+	}
+}
+
+func TestSignatureAlgorithm(t *testing.T) {
+	tests := []struct {
+		digest, sig asn1.ObjectIdentifier
+		want        x509.SignatureAlgorithm
+	}{
+		{oidSHA256, oidRSAEncryption, x509.SHA256WithRSA},
+		{oidSHA512, oidRSAEncryption, x509.SHA512WithRSA},
+		{oidSHA256, oidSHA256WithRSA, x509.SHA256WithRSA},
+		{oidSHA384, oidECDSAWithSHA384, x509.ECDSAWithSHA384},
+		{oidSHA256, oidSHA512WithRSA, x509.UnknownSignatureAlgorithm},
+		{oidSHA512, oidECDSAWithSHA256, x509.UnknownSignatureAlgorithm},
+	}
+	for _, test := range tests {
+		_, alg, err := signatureAlgorithm(test.digest, test.sig)
+		if test.want == x509.UnknownSignatureAlgorithm {
+			if err == nil {
+				t.Errorf("signatureAlgorithm(%v, %v) accepted mismatch", test.digest, test.sig)
+			}
+			continue
+		}
+		if err != nil || alg != test.want {
+			t.Errorf("signatureAlgorithm(%v, %v) = %v, %v, want %v", test.digest, test.sig, alg, err, test.want)
+		}
+	}
+}
+
+func TestSMIMEIntermediate(t *testing.T) {
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	ca := newTestCert(t, "CA", caKey, nil, nil)
+	interKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	inter := newTestCert(t, "Intermediate", interKey, ca, caKey)
+	inter.IsCA = true
+	interTmpl := *inter
+	interTmpl.BasicConstraintsValid = true
+	interTmpl.KeyUsage |= x509.KeyUsageCertSign
+	interTmpl.ExtKeyUsage = nil
+	der, err := x509.CreateCertificate(rand.Reader, &interTmpl, ca, interKey.Public(), caKey)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+	inter, _ = x509.ParseCertificate(der)
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	cert := newTestCert(t, "trillian@example.com", key, inter, interKey)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+
+	var b bytes.Buffer
+	contentType, err := SignSMIME(&b, "multipart/related; boundary=example-1", []byte(testBody), cert, key, inter)
+	if err != nil {
+		t.Fatalf("SignSMIME: %v", err)
+	}
+	if _, signer, err := VerifySMIME(b.Bytes(), contentType, roots); err != nil || !signer.Equal(cert) {
+		t.Errorf("VerifySMIME = %v, %v", signer, err)
+	}
+
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	if _, err := SignSMIME(&b, "multipart/related; boundary=example-1", []byte(testBody), cert, edKey); err != ErrUnsupportedKey {
+		t.Errorf("SignSMIME = %v, want %v", err, ErrUnsupportedKey)
+	}
+}
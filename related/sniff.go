@@ -0,0 +1,178 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package related
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"net/textproto"
+	"regexp"
+	"strings"
+)
+
+// maxSniff limits the bytes SniffReader reads looking for the first
+// delimiter line.
+const maxSniff = 1 << 20
+
+// headerLinePattern matches the beginning of a header field.
+var headerLinePattern = regexp.MustCompile(`^[!-9;-~]+:`)
+
+// SniffReader returns a new multipart/related Reader for a body of unknown
+// boundary, e.g. a saved .mht file. The boundary is taken from the first
+// delimiter line of r followed by a part's header.
+//
+// If r starts with a header block, its Content-Type provides the type,
+// start and start-info parameters. A boundary parameter without delimiter
+// line in the body is replaced by the detected one, with a warning.
+func SniffReader(r io.Reader) (*Reader, error) {
+	s := &sniffer{br: bufio.NewReader(r)}
+	params := make(map[string]string)
+	var warnings []string
+
+	body := 0
+	var header textproto.MIMEHeader
+	if s.readLine() && headerLinePattern.Match(s.head) && !bytes.HasPrefix(s.head, []byte("--")) {
+		for len(bytes.TrimRight(s.head[s.line:], "\r\n")) > 0 && s.readLine() {
+		}
+		tr := textproto.NewReader(bufio.NewReader(bytes.NewReader(s.head)))
+		if h, err := tr.ReadMIMEHeader(); err == nil {
+			header = h
+			body = len(s.head)
+			ct := header.Get("Content-Type")
+			mediaType, p, err := mime.ParseMediaType(ct)
+			if err == nil && mediaType == "multipart/related" {
+				params = p
+			} else if ct != "" {
+				warnings = append(warnings, fmt.Sprintf("Content-Type %q of header block ignored", ct))
+			}
+		}
+	}
+
+	boundary := ""
+	if b := params["boundary"]; b != "" {
+		boundary = s.findBoundary(body, b)
+	}
+	if boundary == "" {
+		boundary = s.findBoundary(body, "")
+		if boundary == "" {
+			return nil, ErrNoBoundary
+		}
+		if b := params["boundary"]; b != "" {
+			warnings = append(warnings, fmt.Sprintf("boundary parameter %q doesn't match body, using %q", b, boundary))
+		}
+	}
+	params["boundary"] = boundary
+
+	reader := NewReader(io.MultiReader(bytes.NewReader(s.head[body:]), s.br), params)
+	reader.header = header
+	for _, w := range warnings {
+		reader.warn(-1, "%s", w)
+	}
+	return reader, nil
+}
+
+// sniffer buffers the lines read from br.
+type sniffer struct {
+	br   *bufio.Reader
+	head []byte
+	line int // offset of the last line read
+	err  error
+}
+
+// readLine appends the next line to head. It reports false if there is
+// none, or head would exceed maxSniff.
+func (s *sniffer) readLine() bool {
+	if s.err != nil {
+		return false
+	}
+	s.line = len(s.head)
+	for {
+		b, err := s.br.ReadSlice('\n')
+		s.head = append(s.head, b...)
+		if len(s.head) > maxSniff {
+			s.err = ErrNoBoundary
+			return false
+		}
+		if err != bufio.ErrBufferFull {
+			s.err = err
+			return len(s.head) > s.line
+		}
+	}
+}
+
+// findBoundary returns the boundary of the first delimiter line in head
+// from pos on, reading up to maxSniff bytes. If want isn't empty, only
+// delimiter lines of want are considered.
+//
+// Otherwise a delimiter line has to be followed by a part's header, so
+// that preamble lines starting with "--" are skipped. A line ending in
+// "--" followed by anything else is taken as the close delimiter of a
+// message without parts. If no line qualifies, the first delimiter-like
+// line is used.
+func (s *sniffer) findBoundary(pos int, want string) string {
+	first := ""
+	for {
+		line := s.lineAt(pos)
+		if line == nil {
+			return first
+		}
+		pos += len(line)
+		c := delimiterBoundary(line)
+		switch {
+		case c == "":
+			continue
+		case want != "":
+			if c == want || c == want+"--" {
+				return want
+			}
+			continue
+		case first == "":
+			first = c
+		}
+
+		next := s.lineAt(pos)
+		if next != nil && headerLinePattern.Match(next) {
+			return c
+		}
+		if b := strings.TrimSuffix(c, "--"); b != c {
+			if validBoundary(b) {
+				return b
+			}
+			continue
+		}
+		if next != nil && len(bytes.TrimRight(next, "\r\n")) == 0 {
+			return c
+		}
+	}
+}
+
+// lineAt returns the line starting at pos in head, reading it if needed,
+// or nil if there is none.
+func (s *sniffer) lineAt(pos int) []byte {
+	for pos >= len(s.head) {
+		if !s.readLine() {
+			return nil
+		}
+	}
+	end := bytes.IndexByte(s.head[pos:], '\n') + 1
+	if end == 0 {
+		end = len(s.head) - pos
+	}
+	return s.head[pos : pos+end]
+}
+
+// delimiterBoundary returns the boundary of a delimiter line, or an empty
+// string if line isn't one.
+func delimiterBoundary(line []byte) string {
+	l := strings.TrimRight(string(line), " \t\r\n")
+	if !strings.HasPrefix(l, "--") || !validBoundary(l[2:]) {
+		return ""
+	}
+	return l[2:]
+}
@@ -0,0 +1,116 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package related
+
+import (
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestSniffReader(t *testing.T) {
+	tests := []struct {
+		in       string
+		boundary string
+		start    string
+		warnings int
+	}{
+		{testBody, "example-1", "", 0},
+		{"preamble\r\n--\r\n" + testBody, "example-1", "", 0},
+		{"MIME-Version: 1.0\r\n" +
+			"Content-Type: multipart/related; boundary=example-1;\r\n" +
+			"\tstart=\"<a@b.c>\"; type=\"a/b\"\r\n" +
+			"\r\n" + testBody, "example-1", "<a@b.c>", 0},
+		{"Content-Type: multipart/related; boundary=wrong; start=\"<a@b.c>\"\r\n" +
+			"\r\n" + testBody, "example-1", "<a@b.c>", 1},
+		{"Content-Type: text/html\r\n\r\n" + testBody, "example-1", "", 1},
+		{"Not: a header\r\n" + testBody, "example-1", "", 0},
+	}
+	for i, tt := range tests {
+		r, err := SniffReader(strings.NewReader(tt.in))
+		if err != nil {
+			t.Errorf("%d. SniffReader: %v", i, err)
+			continue
+		}
+		object, err := r.ReadObject()
+		if err != nil {
+			t.Errorf("%d. ReadObject: %v", i, err)
+			continue
+		}
+		if g := object.Params["boundary"]; g != tt.boundary {
+			t.Errorf("%d. boundary = %q, want %q", i, g, tt.boundary)
+		}
+		if g := object.Params["start"]; g != tt.start {
+			t.Errorf("%d. start = %q, want %q", i, g, tt.start)
+		}
+		if len(object.Values) != 2 {
+			t.Errorf("%d. ReadObject = %d parts, want 2", i, len(object.Values))
+		}
+		if g := len(r.Warnings()); g != tt.warnings {
+			t.Errorf("%d. Warnings = %v, want %d", i, r.Warnings(), tt.warnings)
+		}
+	}
+}
+
+func TestSniffReaderCloseDelimiter(t *testing.T) {
+	for _, in := range []string{"--b--\r\n", "preamble\r\n--b--\r\nepilogue"} {
+		r, err := SniffReader(strings.NewReader(in))
+		if err != nil {
+			t.Fatalf("SniffReader(%q): %v", in, err)
+		}
+		if g := r.params["boundary"]; g != "b" {
+			t.Errorf("SniffReader(%q) boundary = %q, want b", in, g)
+		}
+		if _, err := r.NextPart(); err != io.EOF {
+			t.Errorf("SniffReader(%q).NextPart = %v, want %v", in, err, io.EOF)
+		}
+	}
+}
+
+func TestSniffReaderCandidates(t *testing.T) {
+	part := "Content-Type: text/plain\r\n\r\nhi\r\n"
+	tests := []struct {
+		in       string
+		boundary string
+		warnings int
+	}{
+		{"--=_abc--\r\n" + part + "--=_abc----\r\n", "=_abc--", 0},
+		{"-- saved by tool\r\n--b\r\n" + part + "--b--\r\n", "b", 0},
+		{"Content-Type: multipart/related; boundary=real\r\n\r\n" +
+			"-- saved by tool\r\n--real\r\n" + part + "--real--\r\n", "real", 0},
+		{"Content-Type: multipart/related; boundary=wrong\r\n\r\n" +
+			"-- saved by tool\r\n--b\r\n" + part + "--b--\r\n", "b", 1},
+	}
+	for _, tt := range tests {
+		r, err := SniffReader(strings.NewReader(tt.in))
+		if err != nil {
+			t.Errorf("SniffReader(%q): %v", tt.in, err)
+			continue
+		}
+		object, err := r.ReadObject()
+		if err != nil {
+			t.Errorf("%q: ReadObject: %v", tt.in, err)
+			continue
+		}
+		if g := object.Params["boundary"]; g != tt.boundary {
+			t.Errorf("%q: boundary = %q, want %q", tt.in, g, tt.boundary)
+		}
+		if len(object.Values) != 1 || string(object.Values[0].Bytes()) != "hi" {
+			t.Errorf("%q: ReadObject = %d parts, want hi", tt.in, len(object.Values))
+		}
+		if g := len(r.Warnings()); g != tt.warnings {
+			t.Errorf("%q: Warnings = %v, want %d", tt.in, r.Warnings(), tt.warnings)
+		}
+	}
+}
+
+func TestSniffReaderNoBoundary(t *testing.T) {
+	for _, in := range []string{"", "Content-Type: text/plain\r\n\r\nLife?", strings.Repeat("-", maxSniff+1)} {
+		if _, err := SniffReader(strings.NewReader(in)); err != ErrNoBoundary {
+			t.Errorf("SniffReader(%.20q) = %v, want %v", in, err, ErrNoBoundary)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package related
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/textproto"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ManifestName is the name of the manifest written by Object.Extract.
+const ManifestName = "manifest.json"
+
+// ErrUnsafePath is returned if extracting a part would leave the target
+// directory, e.g. through a symbolic link.
+var ErrUnsafePath = errors.New("unsafe path")
+
+// A ManifestEntry describes an extracted part.
+type ManifestEntry struct {
+	// Path is the slash-separated path of the part's file, relative to
+	// the extraction directory.
+	Path      string               `json:"path"`
+	Root      bool                 `json:"root,omitempty"`
+	ContentId string               `json:"content_id,omitempty"`
+	Size      int                  `json:"size"`
+	Header    textproto.MIMEHeader `json:"header"`
+}
+
+// Extract writes each part to a file in dir, creating dir if needed, and
+// lists them in ManifestName. Files are named after the part's
+// Content-Location, the filename of its Content-Disposition or its
+// Content-ID, in this order.
+//
+// Names are treated as hostile: absolute paths and ".." elements can't
+// leave dir, unsafe characters are replaced, names colliding with each
+// other (ignoring case) get a numeric suffix, and neither existing files
+// nor symbolic links below dir are followed or overwritten.
+func (o *Object) Extract(dir string) ([]*ManifestEntry, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	n := newNamer()
+	n.claim(ManifestName)
+	var manifest []*ManifestEntry
+	for i, oh := range o.Values {
+		name := n.claim(partFileName(oh, i))
+		if err := writeFileIn(dir, name, oh.content); err != nil {
+			return nil, err
+		}
+		manifest = append(manifest, &ManifestEntry{
+			Path:      name,
+			Root:      oh.Root,
+			ContentId: oh.ContentId(),
+			Size:      len(oh.content),
+			Header:    oh.Header,
+		})
+	}
+
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeFileIn(dir, ManifestName, append(b, '\n')); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// partFileName proposes a sanitized, slash-separated file name for oh.
+func partFileName(oh *ObjectHeader, i int) string {
+	if name := sanitizePath(locationPath(oh.location())); name != "" {
+		return name
+	}
+	if _, params, err := mime.ParseMediaType(oh.Header.Get("Content-Disposition")); err == nil {
+		filename := strings.Replace(params["filename"], "\\", "/", -1)
+		if name := sanitizePath(path.Base(filename)); name != "" {
+			return name
+		}
+	}
+	if name := sanitizePath(strings.Replace(oh.ContentId(), "/", "_", -1)); name != "" {
+		return name
+	}
+	return fmt.Sprintf("part-%d", i)
+}
+
+// sanitizePath cleans a slash-separated path, dropping empty, "." and ".."
+// elements and replacing characters unsafe on common file systems.
+func sanitizePath(p string) string {
+	var elems []string
+	for _, e := range strings.Split(p, "/") {
+		e = strings.Map(func(r rune) rune {
+			if r < 0x20 || r == 0x7f || strings.ContainsRune(`\<>:"|?*`, r) {
+				return '_'
+			}
+			return r
+		}, e)
+		e = strings.TrimRight(e, " .")
+		if e == "" || e == "." || e == ".." {
+			continue
+		}
+		if len(e) > 200 {
+			e = e[:200]
+		}
+		elems = append(elems, e)
+	}
+	return strings.Join(elems, "/")
+}
+
+// namer hands out file names that don't collide, ignoring case, neither
+// with each other nor with the directories they imply.
+type namer struct {
+	files map[string]bool
+	dirs  map[string]bool
+}
+
+func newNamer() *namer {
+	return &namer{files: make(map[string]bool), dirs: make(map[string]bool)}
+}
+
+func (n *namer) claim(name string) string {
+	ext := path.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for i := 1; !n.free(name); i++ {
+		name = fmt.Sprintf("%s-%d%s", base, i, ext)
+	}
+	n.files[strings.ToLower(name)] = true
+	for d := path.Dir(name); d != "."; d = path.Dir(d) {
+		n.dirs[strings.ToLower(d)] = true
+	}
+	return name
+}
+
+func (n *namer) free(name string) bool {
+	key := strings.ToLower(name)
+	if n.files[key] || n.dirs[key] {
+		return false
+	}
+	for d := path.Dir(key); d != "."; d = path.Dir(d) {
+		if n.files[d] {
+			return false
+		}
+	}
+	return true
+}
+
+// writeFileIn creates the file name, a slash-separated path, below dir.
+// Missing directories are created; existing ones must not be symbolic
+// links. The file itself must not exist.
+func writeFileIn(dir, name string, content []byte) error {
+	target := dir
+	elems := strings.Split(name, "/")
+	for _, e := range elems[:len(elems)-1] {
+		target = filepath.Join(target, e)
+		fi, err := os.Lstat(target)
+		switch {
+		case os.IsNotExist(err):
+			if err := os.Mkdir(target, 0755); err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		case !fi.IsDir():
+			return fmt.Errorf("%w: %s", ErrUnsafePath, name)
+		}
+	}
+
+	target = filepath.Join(target, elems[len(elems)-1])
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
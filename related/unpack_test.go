@@ -0,0 +1,148 @@
+// Copyright 2015 The multipart-related Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+//
+
+package related
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var testHostileBody = "--example-5\r\n" +
+	"Content-Type: text/html\r\n" +
+	"Content-Location: http://example.com/../../etc/passwd\r\n" +
+	"\r\n" +
+	"root\r\n" +
+	"--example-5\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"Content-Location: /tmp/ABS.txt\r\n" +
+	"\r\n" +
+	"absolute\r\n" +
+	"--example-5\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"Content-Location: tmp/abs.txt\r\n" +
+	"\r\n" +
+	"collision\r\n" +
+	"--example-5\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"Content-Disposition: attachment; filename=\"..\\\\..\\\\evil:.txt\"\r\n" +
+	"\r\n" +
+	"disposition\r\n" +
+	"--example-5\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"Content-ID: <a/b@c>\r\n" +
+	"\r\n" +
+	"cid\r\n" +
+	"--example-5\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"Content-Location: manifest.json\r\n" +
+	"\r\n" +
+	"manifest\r\n" +
+	"--example-5\r\n" +
+	"Content-Type: text/plain\r\n" +
+	"\r\n" +
+	"anonymous\r\n" +
+	"--example-5--\r\n"
+
+func TestObjectExtract(t *testing.T) {
+	dir, err := ioutil.TempDir("", "related")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	object := readTestObject(t, testHostileBody, map[string]string{"boundary": "example-5"}, false)
+	manifest, err := object.Extract(dir)
+	if err != nil {
+		t.Fatalf("Extract: %v", err)
+	}
+
+	want := map[string]string{
+		"example.com/etc/passwd": "root",
+		"tmp/ABS.txt":            "absolute",
+		"tmp/abs-1.txt":          "collision",
+		"evil_.txt":              "disposition",
+		"a_b@c":                  "cid",
+		"manifest-1.json":        "manifest",
+		"part-6":                 "anonymous",
+	}
+	got := make(map[string]string)
+	for _, e := range manifest {
+		b, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(e.Path)))
+		if err != nil {
+			t.Fatalf("ReadFile: %v", err)
+		}
+		got[e.Path] = string(b)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("files = %v, want %v", got, want)
+	}
+	if !manifest[0].Root || manifest[4].ContentId != "a/b@c" {
+		t.Errorf("manifest = %+v", manifest)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, ManifestName))
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	var read []*ManifestEntry
+	if err := json.Unmarshal(b, &read); err != nil {
+		t.Fatalf("manifest: %v", err)
+	}
+	if !reflect.DeepEqual(read, manifest) {
+		t.Errorf("manifest = %+v, want %+v", read, manifest)
+	}
+
+	// A second extraction must not overwrite anything.
+	if _, err := object.Extract(dir); !os.IsExist(err) {
+		t.Errorf("Extract = %v, want file exists", err)
+	}
+}
+
+func TestObjectExtractSymlink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "related")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	outside, err := ioutil.TempDir("", "related")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(outside)
+
+	if err := os.Symlink(outside, filepath.Join(dir, "example.com")); err != nil {
+		t.Skipf("Symlink: %v", err)
+	}
+	object := readTestObject(t, testHostileBody, map[string]string{"boundary": "example-5"}, false)
+	if _, err := object.Extract(dir); !errors.Is(err, ErrUnsafePath) {
+		t.Errorf("Extract = %v, want %v", err, ErrUnsafePath)
+	}
+	if files, _ := ioutil.ReadDir(outside); len(files) != 0 {
+		t.Errorf("Extract wrote through symlink: %v", files)
+	}
+}
+
+func TestSanitizePath(t *testing.T) {
+	tests := []struct {
+		p, w string
+	}{
+		{"../../etc/passwd", "etc/passwd"},
+		{"/abs//path/./x", "abs/path/x"},
+		{"a\x00b/c:d", "a_b/c_d"},
+		{"trailing. /x", "trailing/x"},
+		{"..", ""},
+	}
+	for i, tt := range tests {
+		if g := sanitizePath(tt.p); g != tt.w {
+			t.Errorf("%d. sanitizePath(%q) = %q, want %q", i, tt.p, g, tt.w)
+		}
+	}
+}
@@ -28,7 +28,36 @@ var (
 // A Writer generates multipart/related messages.
 // See http://tools.ietf.org/html/rfc2387
 type Writer struct {
-	w *multipart.Writer
+	w  *multipart.Writer
+	cw *countWriter
+
+	// n is the number of parts created so far
+	n int
+
+	// root locates the root part for PartError
+	root *PartError
+
+	// header is the message header block, see SetMessageHeader, and
+	// written the Content-Type it was written with
+	header  textproto.MIMEHeader
+	written string
+
+	// checksums are added to each part, which is buffered in pending
+	// until it's complete
+	checksums Checksum
+	pending   *pendingPart
+
+	// deterministic output, see SetDeterministic; with deferAll, all
+	// parts are buffered in deferred until Close
+	deterministic  bool
+	deriveBoundary bool
+	deferAll       bool
+	deferred       []*pendingPart
+
+	// collisions is the CollisionPolicy, and delims counts the delimiter
+	// lines written for the self-check
+	collisions CollisionPolicy
+	delims     *delimCounter
 
 	// start is the content-ID of the compound object's "root"; optional
 	start string
@@ -52,8 +81,10 @@ type Writer struct {
 // NewWriter returns a new multipart/related Writer with a random
 // boundary, writing to w. It's a wrapper around multipart's Writer
 func NewWriter(w io.Writer) *Writer {
+	cw := &countWriter{w: w}
 	return &Writer{
-		w:         multipart.NewWriter(w),
+		w:         multipart.NewWriter(cw),
+		cw:        cw,
 		firstPart: false,
 		rootPart:  false,
 	}
@@ -81,6 +112,9 @@ func (w *Writer) SetStart(contentId string) error {
 	if err != nil {
 		return err
 	}
+	if _, err := encodeHeaderValue("Content-ID", cid); err != nil {
+		return err
+	}
 
 	w.start = cid
 	return nil
@@ -98,6 +132,11 @@ func formatContentId(contentId string) (string, error) {
 
 // SetType changes MIME mediaType of the compound object
 func (w *Writer) SetType(mediaType string) error {
+	// ParseMediaType ignores surrounding white space, including line
+	// breaks, which would end up in the root's header.
+	if _, err := encodeHeaderValue("Content-Type", mediaType); err != nil {
+		return err
+	}
 	if _, _, err := mime.ParseMediaType(mediaType); err != nil {
 		return err
 	}
@@ -140,6 +179,10 @@ func (w *Writer) FormDataContentType() string {
 // Transfer-Encoding), If Content-Id or Content-Type is specified in
 // header, they will be overridden. If header is nil, creates a empty
 // MIMEHeader.
+//
+// Header fields are validated, a *HeaderError being returned for e.g. a
+// line break in a value. Non-ASCII values are encoded and long lines are
+// folded.
 func (w *Writer) CreateRoot(
 	contentId string,
 	mediaType string,
@@ -147,11 +190,12 @@ func (w *Writer) CreateRoot(
 ) (io.Writer, error) {
 
 	if w.rootPart {
-		return nil, ErrRootExists
+		return nil, w.error(contentId, ErrRootExists)
 	}
 
-	if header == nil {
-		header = make(textproto.MIMEHeader)
+	header, err := encodeHeader(header)
+	if err != nil {
+		return nil, w.error(contentId, err)
 	}
 
 	if mediaType == "" {
@@ -159,25 +203,92 @@ func (w *Writer) CreateRoot(
 	}
 
 	if err := w.SetType(mediaType); err != nil {
-		return nil, err
+		return nil, w.error(contentId, err)
 	}
 	header.Set("Content-Type", w.mediaType)
 	w.rootMediaType = w.mediaType
 
 	if contentId != "" {
 		if err := w.SetStart(contentId); err != nil {
-			return nil, err
+			return nil, w.error(contentId, err)
 		}
 		header.Set("Content-ID", w.start)
 	}
 
+	if w.written != "" && w.FormDataContentType() != w.written {
+		return nil, w.error(contentId, ErrHeaderWritten)
+	}
+
 	w.firstPart = true
 	w.rootPart = true
+	w.root = &PartError{
+		Part:      w.n,
+		ContentId: parseContentId(w.start),
+		Offset:    w.cw.n,
+	}
 
-	return w.w.CreatePart(header)
+	return w.createPart(header)
 }
 
-// CreatePart is a wrapper around mulipart's Writer.CreatePart
+// createPart creates the next part, buffering it if checksums are set.
+func (w *Writer) createPart(header textproto.MIMEHeader) (io.Writer, error) {
+	if err := w.flush(); err != nil {
+		return nil, err
+	}
+	if w.checksums != 0 || w.deferAll {
+		p := &pendingPart{header: header, index: w.n}
+		if w.deferAll {
+			w.deferred = append(w.deferred, p)
+		} else {
+			w.pending = p
+		}
+		w.n++
+		return &p.body, nil
+	}
+	pw, err := w.startPart(w.n, header)
+	if err != nil {
+		return nil, w.error(header.Get("Content-Id"), err)
+	}
+	w.n++
+	return pw, nil
+}
+
+// startPart starts the next part on the wire, after the message header
+// block if any.
+func (w *Writer) startPart(index int, header textproto.MIMEHeader) (io.Writer, error) {
+	if err := w.writeHeader(); err != nil {
+		return nil, err
+	}
+	part := PartError{
+		Part:      index,
+		ContentId: parseContentId(header.Get("Content-Id")),
+		Offset:    w.cw.n,
+	}
+	if w.deterministic {
+		header = canonicalCasing(header)
+	}
+	pw, err := w.w.CreatePart(foldHeader(header))
+	if err != nil || w.collisions == CollisionIgnore {
+		return pw, err
+	}
+	return newCollisionWriter(pw, w.Boundary(), part), nil
+}
+
+// error wraps err in a PartError locating the next part.
+func (w *Writer) error(contentId string, err error) error {
+	if cid := parseContentId(contentId); cid != "" {
+		contentId = cid
+	}
+	return &PartError{
+		Part:      w.n,
+		ContentId: contentId,
+		Offset:    w.cw.n,
+		Err:       err,
+	}
+}
+
+// CreatePart is a wrapper around mulipart's Writer.CreatePart. header
+// is validated and encoded as with CreateRoot.
 func (w *Writer) CreatePart(
 	contentId string,
 	header textproto.MIMEHeader,
@@ -188,14 +299,21 @@ func (w *Writer) CreatePart(
 	if header == nil {
 		header = make(textproto.MIMEHeader)
 		header.Set("Content-Type", mediaType)
-	} else if header.Get("Content-Type") != "" {
-		mediaType = header.Get("Content-Type")
+	} else {
+		h, err := encodeHeader(header)
+		if err != nil {
+			return nil, w.error(contentId, err)
+		}
+		header = h
+		if header.Get("Content-Type") != "" {
+			mediaType = header.Get("Content-Type")
+		}
 	}
 
 	if contentId != "" {
 		cid, err := formatContentId(contentId)
 		if err != nil {
-			return nil, err
+			return nil, w.error(contentId, err)
 		}
 		header.Set("Content-ID", cid)
 	}
@@ -204,16 +322,74 @@ func (w *Writer) CreatePart(
 		w.SetType(mediaType)
 		w.rootMediaType = w.mediaType
 		w.firstPart = true
+		w.root = &PartError{
+			Part:      w.n,
+			ContentId: parseContentId(header.Get("Content-Id")),
+			Offset:    w.cw.n,
+		}
 	}
-	return w.w.CreatePart(header)
+	return w.createPart(header)
+}
+
+// CopyPart writes a parsed part, keeping its headers. A root part is
+// written with CreateRoot, taking the compound object's start and type
+// from its Content-ID and Content-Type.
+func (w *Writer) CopyPart(p *Part) error {
+	header := make(textproto.MIMEHeader, len(p.Header))
+	for k, v := range p.Header {
+		header[k] = append([]string(nil), v...)
+	}
+
+	var pw io.Writer
+	var err error
+	if p.Root {
+		contentId := parseContentId(header.Get("Content-Id"))
+		pw, err = w.CreateRoot(contentId, header.Get("Content-Type"), header)
+	} else {
+		pw, err = w.CreatePart("", header)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(pw, p)
+	return err
 }
 
 // Close is a wrapper around multipart's Writer.Close with additional errors.
+// A mismatch of the root's media type is returned as *PartError locating
+// the root.
 func (w *Writer) Close() error {
 	if w.mediaType != w.rootMediaType {
-		return ErrTypeMatch
+		if w.root == nil {
+			return ErrTypeMatch
+		}
+		err := *w.root
+		err.Err = ErrTypeMatch
+		return &err
+	}
+	if err := w.flush(); err != nil {
+		return err
+	}
+	if w.deferAll {
+		w.deferAll = false
+		if err := w.selectBoundary(); err != nil {
+			return err
+		}
+		for _, p := range w.deferred {
+			w.pending = p
+			if err := w.flush(); err != nil {
+				return err
+			}
+		}
+		w.deferred = nil
+	}
+	if err := w.writeHeader(); err != nil {
+		return err
+	}
+	if err := w.w.Close(); err != nil {
+		return err
 	}
-	return w.w.Close()
+	return w.selfCheck()
 }
 
 // Helper func: escapeQuotes, borrowed from stdlib
@@ -7,10 +7,13 @@ package related
 
 import (
 	"bytes"
+	"errors"
+	"io"
 	"io/ioutil"
 	"mime"
 	"net/textproto"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -103,7 +106,7 @@ func TestCreateRootFail(t *testing.T) {
 
 	for i := 2; i > 0; i-- {
 		_, err := w.CreateRoot("", "a/b", nil)
-		if i == 1 && err != ErrRootExists {
+		if i == 1 && !errors.Is(err, ErrRootExists) {
 			t.Errorf("%d. Multiple CreateRoot: Expected error", i)
 		}
 	}
@@ -253,7 +256,7 @@ func TestClose(t *testing.T) {
 	if err := w.SetType("text/html"); err != nil {
 		t.Fatalf("SetType: %v", err)
 	}
-	if err := w.Close(); err != ErrTypeMatch {
+	if err := w.Close(); !errors.Is(err, ErrTypeMatch) {
 		t.Errorf("NoMediaType = %v; want %q", err, ErrTypeMatch)
 	}
 	w.Close()
@@ -325,3 +328,42 @@ func TestFormatContentId(t *testing.T) {
 		}
 	}
 }
+
+func TestCopyPart(t *testing.T) {
+	r := NewReader(strings.NewReader(testMovedRootBody), testParams)
+	var b bytes.Buffer
+	w := NewWriter(&b)
+	for {
+		p, err := r.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("NextPart: %v", err)
+		}
+		if err := w.CopyPart(p); err != nil {
+			t.Fatalf("CopyPart: %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	if g, w := w.start, "<a@b.c>"; g != w {
+		t.Errorf("start = %s, want %s", g, w)
+	}
+	if g, w := w.mediaType, "b/c"; g != w {
+		t.Errorf("type = %s, want %s", g, w)
+	}
+	_, params, _ := mime.ParseMediaType(w.FormDataContentType())
+	object, err := NewReader(&b, params).ReadObject()
+	if err != nil {
+		t.Fatalf("ReadObject: %v", err)
+	}
+	want := []string{"Don't talk to me about life!", "Life?"}
+	for i, oh := range object.Values {
+		if g := string(oh.Bytes()); g != want[i] {
+			t.Errorf("%d. content = %q, want %q", i, g, want[i])
+		}
+	}
+}